@@ -71,6 +71,7 @@ type session struct {
 	timerLock      sync.Mutex
 	finalized      bool
 	isOnline       chan struct{}
+	packetIDs      packet.PacketIDAllocator
 }
 
 type sessionWrap struct {
@@ -99,6 +100,7 @@ func newSession(c *sessionPreConfig) *session {
 		createdAt:     c.createdAt,
 		messenger:     c.messenger,
 		isOnline:      make(chan struct{}),
+		packetIDs:     packet.NewPacketIDAllocator(),
 	}
 
 	s.timer = time.AfterFunc(10*time.Second, s.timerCallback)
@@ -117,6 +119,9 @@ func (s *session) reconfigure(c *sessionReConfig, runExpiry bool) {
 }
 
 func (s *session) allocConnection(c *connection.PreConfig) error {
+	// packet IDs of in-flight messages survive reconnect of the session
+	c.PacketIDs = s.packetIDs
+
 	cfg := &connection.Config{
 		PreConfig:        c,
 		ID:               s.id,
@@ -24,6 +24,7 @@ import (
 	"github.com/VolantMQ/volantmq/types"
 	"github.com/troian/easygo/netpoll"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 // load sessions owning subscriptions
@@ -42,6 +43,8 @@ type Config struct {
 	ConnectTimeout                int
 	KeepAlive                     int
 	MaxPacketSize                 uint32
+	MaxRxPacketRate               int
+	RxInspect                     func(raw []byte) error
 	ReceiveMax                    uint16
 	TopicAliasMaximum             uint16
 	MaximumQoS                    packet.QosType
@@ -290,6 +293,11 @@ func (m *Manager) getWill(pkt *packet.Connect) *packet.Publish {
 func (m *Manager) newConnectionPreConfig(config *StartConfig) *connection.PreConfig {
 	username, _ := config.Req.Credentials()
 
+	var rxRate *rate.Limiter
+	if m.MaxRxPacketRate > 0 {
+		rxRate = rate.NewLimiter(rate.Limit(m.MaxRxPacketRate), m.MaxRxPacketRate)
+	}
+
 	return &connection.PreConfig{
 		Username:        string(username),
 		Auth:            config.Auth,
@@ -305,6 +313,8 @@ func (m *Manager) newConnectionPreConfig(config *StartConfig) *connection.PreCon
 		RetainAvailable: m.AvailableRetain,
 		OfflineQoS0:     m.OfflineQoS0,
 		MaxRxPacketSize: m.MaxPacketSize,
+		RxRate:          rxRate,
+		RxInspect:       m.RxInspect,
 		MaxRxTopicAlias: m.TopicAliasMaximum,
 		MaxTxTopicAlias: 0,
 	}
@@ -30,6 +30,7 @@ import (
 	"github.com/VolantMQ/volantmq/types"
 	"github.com/troian/easygo/netpoll"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 // nolint: golint
@@ -75,6 +76,9 @@ type PreConfig struct {
 	Conn            net.Conn
 	Auth            auth.SessionPermissions
 	Desc            *netpoll.Desc
+	RxRate          *rate.Limiter
+	RxInspect       func(raw []byte) error
+	PacketIDs       packet.PacketIDAllocator
 	MaxRxPacketSize uint32
 	MaxTxPacketSize uint32
 	SendQuota       int32
@@ -128,11 +132,11 @@ type Type struct {
 		lock sync.Mutex
 		list []*packet.Publish
 	}
-	flowInUse         sync.Map
-	flowCounter       uint64
+	flowIDs           packet.PacketIDAllocator
 	rxRemaining       int
 	txRunning         uint32
 	rxRunning         uint32
+	flowIDsExhausted  uint32
 	topicAliasCurrMax uint16
 	txQuotaExceeded   bool
 	will              bool
@@ -164,6 +168,11 @@ func New(c *Config) (s *Type, err error) {
 
 	s.txTimer.Stop()
 
+	// each session owns packet ID space unless session manager provides allocator
+	if s.flowIDs = c.PacketIDs; s.flowIDs == nil {
+		s.flowIDs = packet.NewPacketIDAllocator()
+	}
+
 	s.started.Add(1)
 	s.pubIn.onRelease = s.onReleaseIn
 	s.pubOut.onRelease = s.onReleaseOut
@@ -302,10 +311,22 @@ func (s *Type) loadPersistence() error {
 			switch p := pkt.(type) {
 			case *packet.Publish:
 				id, _ := p.ID()
-				s.flowReAcquire(id)
+				if !s.flowReAcquire(id) {
+					// packet ID collides with another restored flow, deliver as new message with fresh ID
+					s.log.Warn("Persisted packet ID in use, requeue message",
+						zap.String("ClientID", s.ID), zap.Uint16("ID", uint16(id)))
+					p.SetDup(false)
+					s.qLoad(p)
+					return nil
+				}
 			case *packet.Ack:
 				id, _ := p.ID()
-				s.flowReAcquire(id)
+				if !s.flowReAcquire(id) {
+					// release of QoS 2 flow must refer to its own packet ID, thus cannot be reassigned
+					s.log.Error("Persisted packet ID in use, drop message",
+						zap.String("ClientID", s.ID), zap.String("Type", p.Type().Name()), zap.Uint16("ID", uint16(id)))
+					return nil
+				}
 			}
 
 			s.qLoad(&unacknowledged{packet: pkt})
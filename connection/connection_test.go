@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"testing"
+
+	"github.com/VolantMQ/persistence"
+	"github.com/VolantMQ/volantmq/packet"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type packetsState struct {
+	persistence.Packets
+	entries []persistence.PersistedPacket
+}
+
+func (p *packetsState) PacketsForEach(id []byte, f func(persistence.PersistedPacket) error) error {
+	for _, e := range p.entries {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTestConnection(ids packet.PacketIDAllocator, state persistence.Packets) *Type {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				Version:   packet.ProtocolV311,
+				SendQuota: 10,
+				PacketIDs: ids,
+				State:     state,
+			},
+		},
+		log:     zap.NewNop(),
+		flowIDs: ids,
+	}
+
+	return s
+}
+
+func persistedPublish(t *testing.T, id packet.IDType, topic string) persistence.PersistedPacket {
+	m, err := packet.New(packet.ProtocolV311, packet.PUBLISH)
+	require.NoError(t, err)
+
+	pub := m.(*packet.Publish)
+	require.NoError(t, pub.Set(topic, []byte("data"), packet.QoS1, false, true))
+	pub.SetPacketID(id)
+
+	buf, err := packet.Encode(pub)
+	require.NoError(t, err)
+
+	return persistence.PersistedPacket{UnAck: true, Data: buf}
+}
+
+func persistedRelease(t *testing.T, id packet.IDType) persistence.PersistedPacket {
+	m, err := packet.New(packet.ProtocolV311, packet.PUBREL)
+	require.NoError(t, err)
+
+	ack := m.(*packet.Ack)
+	ack.SetPacketID(id)
+
+	buf, err := packet.Encode(ack)
+	require.NoError(t, err)
+
+	return persistence.PersistedPacket{UnAck: true, Data: buf}
+}
+
+func TestLoadPersistencePacketIDCollision(t *testing.T) {
+	state := &packetsState{
+		entries: []persistence.PersistedPacket{
+			persistedPublish(t, 5, "a"),
+			persistedPublish(t, 5, "b"),
+			persistedRelease(t, 5),
+			persistedRelease(t, 6),
+		},
+	}
+
+	s := newTestConnection(packet.NewPacketIDAllocator(), state)
+	require.NoError(t, s.loadPersistence())
+
+	// colliding PUBREL is dropped, colliding PUBLISH is queued as new message
+	require.Equal(t, 3, s.txQMessages.Len())
+	require.Equal(t, int32(8), s.SendQuota)
+
+	elem := s.txQMessages.Front()
+	first, ok := elem.Value.(*unacknowledged)
+	require.True(t, ok)
+	require.Equal(t, "a", first.packet.(*packet.Publish).Topic())
+
+	elem = elem.Next()
+	requeued, ok := elem.Value.(*packet.Publish)
+	require.True(t, ok)
+	require.Equal(t, "b", requeued.Topic())
+	require.False(t, requeued.Dup())
+
+	elem = elem.Next()
+	rel, ok := elem.Value.(*unacknowledged)
+	require.True(t, ok)
+	id, _ := rel.packet.ID()
+	require.Equal(t, packet.IDType(6), id)
+
+	// requeued message gets fresh packet ID
+	require.NotNil(t, s.qPopPacket())
+
+	pkt := s.qPopPacket()
+	require.NotNil(t, pkt)
+	id, _ = pkt.ID()
+	require.Equal(t, packet.IDType(1), id)
+}
+
+func TestSessionPacketIDsSurviveReconnect(t *testing.T) {
+	ids := packet.NewPacketIDAllocator()
+
+	s := newTestConnection(ids, nil)
+
+	for _, topic := range []string{"a", "b"} {
+		m, err := packet.New(packet.ProtocolV311, packet.PUBLISH)
+		require.NoError(t, err)
+
+		pub := m.(*packet.Publish)
+		require.NoError(t, pub.Set(topic, []byte("data"), packet.QoS1, false, false))
+		s.txQMessages.PushBack(pub)
+	}
+
+	first := s.qPopPacket()
+	require.NotNil(t, first)
+	require.NotNil(t, s.qPopPacket())
+
+	// first message acknowledged, second one still in flight on disconnect
+	id, _ := first.ID()
+	s.pubOut.release(first)
+	s.flowRelease(id)
+	s.flowReleaseAll()
+
+	// next connection of the session restores in-flight message with its ID
+	state := &packetsState{
+		entries: []persistence.PersistedPacket{persistedPublish(t, 2, "b")},
+	}
+
+	s = newTestConnection(ids, state)
+	require.NoError(t, s.loadPersistence())
+	require.Equal(t, int32(9), s.SendQuota)
+
+	m, err := packet.New(packet.ProtocolV311, packet.PUBLISH)
+	require.NoError(t, err)
+
+	pub := m.(*packet.Publish)
+	require.NoError(t, pub.Set("c", []byte("data"), packet.QoS1, false, false))
+	s.txQMessages.PushBack(pub)
+
+	require.NotNil(t, s.qPopPacket())
+
+	// allocator continues sequence of the session and skips restored ID
+	pkt := s.qPopPacket()
+	require.NotNil(t, pkt)
+	id, _ = pkt.ID()
+	require.Equal(t, packet.IDType(3), id)
+}
@@ -10,6 +10,7 @@ import (
 var (
 	errExit          = errors.New("exit")
 	errQuotaExceeded = errors.New("quota exceeded")
+	errNoPacketID    = errors.New("packet ID not available")
 )
 
 //type packetsFlowControl struct {
@@ -19,9 +20,16 @@ var (
 //	quota   int32
 //}
 
-func (s *Type) flowReAcquire(id packet.IDType) {
+// flowReAcquire restores flow of persisted in-flight message
+// false returned if packet ID is already in use by another flow
+func (s *Type) flowReAcquire(id packet.IDType) bool {
+	if !s.flowIDs.Acquire(id) {
+		return false
+	}
+
 	atomic.AddInt32(&s.SendQuota, -1)
-	s.flowInUse.Store(id, true)
+
+	return true
 }
 
 func (s *Type) flowAcquire() (packet.IDType, error) {
@@ -31,26 +39,38 @@ func (s *Type) flowAcquire() (packet.IDType, error) {
 	default:
 	}
 
+	// packet ID acquired before quota so quota stays untouched if all IDs are in flight
+	id, ok := s.flowIDs.Next()
+	if !ok {
+		atomic.StoreUint32(&s.flowIDsExhausted, 1)
+		return 0, errNoPacketID
+	}
+
 	var err error
 	if atomic.AddInt32(&s.SendQuota, -1) == 0 {
 		err = errQuotaExceeded
 	}
 
-	var id packet.IDType
-
-	for count := 0; count <= 0xFFFF; count++ {
-		s.flowCounter++
-		id = packet.IDType(s.flowCounter)
-		if _, ok := s.flowInUse.LoadOrStore(id, true); !ok {
-			break
-		}
-	}
-
 	return id, err
 }
 
+// flowRelease returns true if transmitter stalled on quota or packet IDs can resume
 func (s *Type) flowRelease(id packet.IDType) bool {
-	s.flowInUse.Delete(id)
+	s.flowIDs.Release(id)
+
+	quota := atomic.AddInt32(&s.SendQuota, 1) == 1
+	ids := atomic.CompareAndSwapUint32(&s.flowIDsExhausted, 1, 0)
 
-	return atomic.AddInt32(&s.SendQuota, 1) == 1
+	return quota || ids
+}
+
+// flowReleaseAll returns packet IDs of all in-flight messages to the allocator
+// allocator is session scoped, thus next connection of the session re-acquires IDs of restored messages
+func (s *Type) flowReleaseAll() {
+	s.pubOut.messages.Range(func(k, v interface{}) bool {
+		if id, ok := k.(packet.IDType); ok {
+			s.flowIDs.Release(id)
+		}
+		return true
+	})
 }
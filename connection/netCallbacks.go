@@ -95,6 +95,8 @@ func (s *Type) onConnectionClose(will bool, err error) {
 			s.persist()
 		}
 
+		s.flowReleaseAll()
+
 		s.OnDisconnect(params)
 	})
 }
@@ -293,10 +295,11 @@ func (s *Type) onUnSubscribe(msg *packet.UnSubscribe) packet.Provider {
 		reason := packet.CodeSuccess
 
 		if authorized {
-			if err := s.Subscriber.UnSubscribe(t); err != nil {
-				s.log.Error("Couldn't unsubscribe from topic", zap.Error(err))
-			} else {
+			if err := s.Subscriber.UnSubscribe(t); err == topicsTypes.ErrNotFound {
 				reason = packet.CodeNoSubscriptionExisted
+			} else if err != nil {
+				s.log.Error("Couldn't unsubscribe from topic", zap.Error(err))
+				reason = packet.CodeUnspecifiedError
 			}
 		} else {
 			reason = packet.CodeNotAuthorized
@@ -310,7 +313,13 @@ func (s *Type) onUnSubscribe(msg *packet.UnSubscribe) packet.Provider {
 
 	id, _ := msg.ID()
 	resp.SetPacketID(id)
-	resp.AddReturnCodes(retCodes) // nolint: errcheck
+
+	// V3.1.1 UNSUBACK has no payload
+	if s.Version >= packet.ProtocolV50 {
+		if err := resp.AddReturnCodes(retCodes); err != nil {
+			s.log.Error("Couldn't add UNSUBACK reason codes", zap.String("ClientID", s.ID), zap.Error(err))
+		}
+	}
 
 	return resp
 }
@@ -0,0 +1,77 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VolantMQ/volantmq/packet"
+	"github.com/VolantMQ/volantmq/subscriber"
+	topicsTypes "github.com/VolantMQ/volantmq/topics/types"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type unSubscriber struct {
+	subscriber.ConnectionProvider
+	errs map[string]error
+}
+
+func (u *unSubscriber) UnSubscribe(topic string) error {
+	return u.errs[topic]
+}
+
+func TestOnUnSubscribeReasonCodes(t *testing.T) {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				Version: packet.ProtocolV50,
+			},
+			Subscriber: &unSubscriber{
+				errs: map[string]error{
+					"missing": topicsTypes.ErrNotFound,
+					"broken":  errors.New("broken"),
+				},
+			},
+		},
+		log: zap.NewNop(),
+	}
+
+	m, err := packet.New(packet.ProtocolV50, packet.UNSUBSCRIBE)
+	require.NoError(t, err)
+
+	msg := m.(*packet.UnSubscribe)
+	msg.SetPacketID(10)
+	require.NoError(t, msg.AddTopic("a/b"))
+	require.NoError(t, msg.AddTopic("missing"))
+	require.NoError(t, msg.AddTopic("broken"))
+
+	resp, ok := s.onUnSubscribe(msg).(*packet.UnSubAck)
+	require.True(t, ok)
+
+	buf, err := packet.Encode(resp)
+	require.NoError(t, err)
+
+	// fixed header, packet ID, empty properties and reason code per topic
+	require.Equal(t, []byte{
+		byte(packet.UNSUBACK << 4), 6,
+		0, 10,
+		0,
+		byte(packet.CodeSuccess),
+		byte(packet.CodeNoSubscriptionExisted),
+		byte(packet.CodeUnspecifiedError),
+	}, buf)
+
+	// V3.1.1 UNSUBACK carries packet ID only
+	s.Version = packet.ProtocolV311
+
+	m, err = packet.New(packet.ProtocolV311, packet.UNSUBSCRIBE)
+	require.NoError(t, err)
+
+	msg = m.(*packet.UnSubscribe)
+	msg.SetPacketID(11)
+	require.NoError(t, msg.AddTopic("a/b"))
+
+	buf, err = packet.Encode(s.onUnSubscribe(msg))
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(packet.UNSUBACK << 4), 2, 0, 11}, buf)
+}
@@ -107,6 +107,12 @@ func (s *Type) readPacket(buf *bufio.Reader) (packet.Provider, error) {
 			}
 		}
 
+		// limit ingress rate when configured
+		// checked once fixed header is framed so failed reads do not count as packets
+		if s.RxRate != nil && !s.RxRate.Allow() {
+			return nil, packet.CodeMessageRateTooHigh
+		}
+
 		// Get the remaining length of the message
 		remLen, m := binary.Uvarint(header[1:])
 		// Total message length is remlen + 1 (msg type) + m (remlen bytes)
@@ -128,11 +134,50 @@ func (s *Type) readPacket(buf *bufio.Reader) (packet.Provider, error) {
 		offset += n
 	}
 
-	var pkt packet.Provider
-	pkt, _, err = packet.Decode(s.Version, s.rxRecv)
+	raw := s.rxRecv
 
 	s.rxRecv = []byte{}
 	s.rxRemaining = 0
 
+	// let auditing hook inspect or reject framed packet before it is decoded
+	// rejected packet closes connection with reason returned by the hook, if it is not
+	// packet.ReasonCode CodeAdministrativeAction is used, thus stream is never resumed mid packet
+	if s.RxInspect != nil {
+		if err = s.RxInspect(raw); err != nil {
+			if _, ok := err.(packet.ReasonCode); !ok {
+				err = packet.CodeAdministrativeAction
+			}
+			return nil, err
+		}
+	}
+
+	var pkt packet.Provider
+	pkt, _, err = packet.Decode(s.Version, raw)
+
+	// decode failures may wrap reason code with details, connection handles reason only
+	if reason, ok := unwrapReason(err); ok {
+		err = reason
+	}
+
 	return pkt, err
 }
+
+// unwrapReason walks chain of wrapped errors looking for packet.ReasonCode
+func unwrapReason(err error) (packet.ReasonCode, bool) {
+	for err != nil {
+		if reason, ok := err.(packet.ReasonCode); ok {
+			return reason, true
+		}
+
+		w, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			break
+		}
+
+		err = w.Unwrap()
+	}
+
+	return 0, false
+}
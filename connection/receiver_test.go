@@ -0,0 +1,152 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/VolantMQ/volantmq/packet"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestReadPacketRateLimit(t *testing.T) {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				MaxRxPacketSize: 1024,
+				Version:         packet.ProtocolV311,
+				RxRate:          rate.NewLimiter(rate.Limit(1), 2),
+			},
+		},
+	}
+
+	pingReq := []byte{byte(packet.PINGREQ << 4), 0}
+	buf := bufio.NewReader(bytes.NewReader(bytes.Repeat(pingReq, 3)))
+
+	for i := 0; i < 2; i++ {
+		pkt, err := s.readPacket(buf)
+		require.NoError(t, err)
+		require.Equal(t, packet.PINGREQ, pkt.Type())
+	}
+
+	_, err := s.readPacket(buf)
+	require.EqualError(t, err, packet.CodeMessageRateTooHigh.Error())
+}
+
+func TestReadPacketRateLimitCountsPackets(t *testing.T) {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				MaxRxPacketSize: 1024,
+				Version:         packet.ProtocolV311,
+				RxRate:          rate.NewLimiter(rate.Limit(1), 2),
+			},
+		},
+	}
+
+	pingReq := []byte{byte(packet.PINGREQ << 4), 0}
+
+	// read attempts on drained stream do not consume tokens
+	for i := 0; i < 5; i++ {
+		_, err := s.readPacket(bufio.NewReader(bytes.NewReader(nil)))
+		require.Equal(t, io.EOF, err)
+	}
+
+	buf := bufio.NewReader(bytes.NewReader(bytes.Repeat(pingReq, 2)))
+
+	for i := 0; i < 2; i++ {
+		pkt, err := s.readPacket(buf)
+		require.NoError(t, err)
+		require.Equal(t, packet.PINGREQ, pkt.Type())
+	}
+}
+
+func TestReadPacketInspect(t *testing.T) {
+	var inspected [][]byte
+
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				MaxRxPacketSize: 1024,
+				Version:         packet.ProtocolV311,
+				RxInspect: func(raw []byte) error {
+					inspected = append(inspected, append([]byte(nil), raw...))
+
+					if bytes.Contains(raw, []byte("forbidden")) {
+						return errors.New("forbidden topic")
+					}
+
+					if bytes.Contains(raw, []byte("$SYS")) {
+						return packet.CodeNotAuthorized
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+
+	pingReq := []byte{byte(packet.PINGREQ << 4), 0}
+
+	m, err := packet.New(packet.ProtocolV311, packet.SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub := m.(*packet.Subscribe)
+	sub.SetPacketID(1)
+	require.NoError(t, sub.AddTopic("forbidden/#", 1))
+
+	subscribe, err := sub.MarshalBinary()
+	require.NoError(t, err)
+
+	require.True(t, sub.RemoveTopic("forbidden/#"))
+	require.NoError(t, sub.AddTopic("$SYS/#", 1))
+
+	sys, err := sub.MarshalBinary()
+	require.NoError(t, err)
+
+	stream := append(append(append(append([]byte(nil), pingReq...), subscribe...), sys...), pingReq...)
+	buf := bufio.NewReader(bytes.NewReader(stream))
+
+	pkt, err := s.readPacket(buf)
+	require.NoError(t, err)
+	require.Equal(t, packet.PINGREQ, pkt.Type())
+
+	// non reason code errors are fatal with administrative action
+	pkt, err = s.readPacket(buf)
+	require.Equal(t, packet.CodeAdministrativeAction, err)
+	require.Nil(t, pkt)
+
+	pkt, err = s.readPacket(buf)
+	require.Equal(t, packet.CodeNotAuthorized, err)
+	require.Nil(t, pkt)
+
+	// rejected packet is consumed, next one is read from stream
+	pkt, err = s.readPacket(buf)
+	require.NoError(t, err)
+	require.Equal(t, packet.PINGREQ, pkt.Type())
+
+	require.Equal(t, [][]byte{pingReq, subscribe, sys, pingReq}, inspected)
+}
+
+func TestReadPacketDecodeReason(t *testing.T) {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				MaxRxPacketSize: 1024,
+				Version:         packet.ProtocolV50,
+			},
+		},
+	}
+
+	// SUBSCRIBE with reserved QoS 3
+	stream := []byte{byte(packet.SUBSCRIBE<<4) | 2, 9, 0, 7, 0, 0, 3, 'a', '/', 'b', 3}
+	buf := bufio.NewReader(bytes.NewReader(stream))
+
+	// detailed decode error is reduced to reason code connection replies with
+	pkt, err := s.readPacket(buf)
+	require.Equal(t, packet.CodeMalformedPacket, err)
+	require.Nil(t, pkt)
+}
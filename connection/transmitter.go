@@ -167,6 +167,12 @@ func (s *Type) qPopPacket() packet.Provider {
 				return nil
 			}
 
+			// keep message at the front of the queue until any of packet IDs released
+			if err == errNoPacketID {
+				s.txQuotaExceeded = true
+				return nil
+			}
+
 			if err == errQuotaExceeded {
 				s.txQuotaExceeded = true
 			}
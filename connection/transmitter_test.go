@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"testing"
+
+	"github.com/VolantMQ/volantmq/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQPopPacketNoPacketID(t *testing.T) {
+	s := &Type{
+		Config: &Config{
+			PreConfig: &PreConfig{
+				Version:   packet.ProtocolV311,
+				SendQuota: 10,
+			},
+		},
+		quit:    make(chan struct{}),
+		flowIDs: packet.NewPacketIDAllocator(),
+	}
+
+	for i := 0; i < 0xFFFF; i++ {
+		_, ok := s.flowIDs.Next()
+		require.True(t, ok)
+	}
+
+	m, err := packet.New(packet.ProtocolV311, packet.PUBLISH)
+	require.NoError(t, err)
+
+	pub := m.(*packet.Publish)
+	require.NoError(t, pub.SetTopic("a/b"))
+	require.NoError(t, pub.SetQoS(packet.QoS1))
+
+	s.txQMessages.PushBack(pub)
+
+	// no ID available, message stays queued and is not sent
+	require.Nil(t, s.qPopPacket())
+	require.Equal(t, 1, s.txQMessages.Len())
+	require.Equal(t, int32(10), s.SendQuota)
+	require.True(t, s.txQuotaExceeded)
+
+	_, err = pub.ID()
+	require.Error(t, err)
+
+	// released ID resumes transmitter even though quota has not been exhausted
+	require.True(t, s.flowRelease(7))
+	require.False(t, s.flowRelease(8))
+	s.txQuotaExceeded = false
+
+	pkt := s.qPopPacket()
+	require.NotNil(t, pkt)
+
+	id, err := pkt.ID()
+	require.NoError(t, err)
+	require.Equal(t, packet.IDType(7), id)
+	require.Equal(t, 0, s.txQMessages.Len())
+	require.Equal(t, int32(11), s.SendQuota)
+}
@@ -61,6 +61,48 @@ func (msg *ConnAck) SetReturnCode(ret ReasonCode) error {
 	return nil
 }
 
+// ReceiveMaximum returns v5 Receive Maximum server is willing to process concurrently
+// and whether property is present. If not present value defaults to 65535
+func (msg *ConnAck) ReceiveMaximum() (uint16, bool) {
+	return msg.receiveMaximum()
+}
+
+// SetReceiveMaximum set v5 Receive Maximum. Value of 0 is not allowed
+func (msg *ConnAck) SetReceiveMaximum(v uint16) error {
+	return msg.setReceiveMaximum(v)
+}
+
+// RetainAvailable returns whether server supports retained messages and whether property is present.
+// If not present value defaults to true
+func (msg *ConnAck) RetainAvailable() (bool, bool) {
+	return msg.boolProperty(PropertyRetainAvailable, true)
+}
+
+// SetRetainAvailable set v5 Retain Available property. Property is omitted if v is true as it is default
+func (msg *ConnAck) SetRetainAvailable(v bool) error {
+	if v {
+		return msg.propertyDelete(PropertyRetainAvailable)
+	}
+
+	return msg.setBoolProperty(PropertyRetainAvailable, v)
+}
+
+// SharedSubscriptionAvailable returns whether server supports shared subscriptions and whether property
+// is present. If not present value defaults to true
+func (msg *ConnAck) SharedSubscriptionAvailable() (bool, bool) {
+	return msg.boolProperty(PropertySharedSubscriptionAvailable, true)
+}
+
+// SetSharedSubscriptionAvailable set v5 Shared Subscription Available property.
+// Property is omitted if v is true as it is default
+func (msg *ConnAck) SetSharedSubscriptionAvailable(v bool) error {
+	if v {
+		return msg.propertyDelete(PropertySharedSubscriptionAvailable)
+	}
+
+	return msg.setBoolProperty(PropertySharedSubscriptionAvailable, v)
+}
+
 func (msg *ConnAck) decodeMessage(from []byte) (int, error) {
 	offset := 0
 
@@ -200,3 +200,76 @@ func TestConnAckCodeWrite(t *testing.T) {
 	_, err = msg.Encode(buf)
 	require.NoError(t, err)
 }
+
+func TestConnAckCapabilities(t *testing.T) {
+	for _, c := range []struct{ retain, shared bool }{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		m, err := New(ProtocolV50, CONNACK)
+		require.NoError(t, err)
+
+		msg, ok := m.(*ConnAck)
+		require.True(t, ok, "Couldn't cast message type")
+
+		// set opposite first to check value is replaced or omitted properly
+		require.NoError(t, msg.SetRetainAvailable(!c.retain))
+		require.NoError(t, msg.SetSharedSubscriptionAvailable(!c.shared))
+		require.NoError(t, msg.SetRetainAvailable(c.retain))
+		require.NoError(t, msg.SetSharedSubscriptionAvailable(c.shared))
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+
+		expectedLen := 3
+		if !c.retain {
+			expectedLen += 2
+		}
+
+		if !c.shared {
+			expectedLen += 2
+		}
+
+		require.Equal(t, expectedLen, int(buf[1]))
+
+		m, _, err = Decode(ProtocolV50, buf)
+		require.NoError(t, err)
+
+		msg, ok = m.(*ConnAck)
+		require.True(t, ok, "Couldn't cast message type")
+
+		v, present := msg.RetainAvailable()
+		require.Equal(t, c.retain, v)
+		require.Equal(t, !c.retain, present)
+
+		v, present = msg.SharedSubscriptionAvailable()
+		require.Equal(t, c.shared, v)
+		require.Equal(t, !c.shared, present)
+	}
+}
+
+func TestConnAckReceiveMaximum(t *testing.T) {
+	m, err := New(ProtocolV50, CONNACK)
+	require.NoError(t, err)
+
+	msg, ok := m.(*ConnAck)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.EqualError(t, msg.SetReceiveMaximum(0), ErrInvalidArgs.Error())
+	require.NoError(t, msg.SetReceiveMaximum(100))
+
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+
+	m, _, err = Decode(ProtocolV50, buf)
+	require.NoError(t, err)
+
+	msg, ok = m.(*ConnAck)
+	require.True(t, ok, "Couldn't cast message type")
+
+	v, ok := msg.ReceiveMaximum()
+	require.True(t, ok)
+	require.Equal(t, uint16(100), v)
+}
@@ -90,6 +90,13 @@ func (msg *Connect) SetClean(v bool) {
 	}
 }
 
+// CleanStart returns v5 Clean Start flag. It occupies same bit as v3.1.1 Clean Session
+// but specifies only whether session is started fresh, while expiration is controlled
+// by Session Expiry Interval property
+func (msg *Connect) CleanStart() bool {
+	return msg.IsClean()
+}
+
 // KeepAlive returns a time interval measured in seconds. Expressed as a 16-bit word,
 // it is the maximum time interval that is permitted to elapse between the point at
 // which the Client finishes transmitting one Control Packet and the point it starts
@@ -149,6 +156,11 @@ func (msg *Connect) SetWill(t string, m []byte, qos QosType, retain bool) error
 		return ErrInvalidArgs
 	}
 
+	// will topic is topic name thus must not contain wildcards
+	if !ValidTopic(t) {
+		return ErrInvalidTopic
+	}
+
 	if retain {
 		msg.connectFlags |= maskConnFlagWillRetain
 	}
@@ -160,6 +172,24 @@ func (msg *Connect) SetWill(t string, m []byte, qos QosType, retain bool) error
 	return nil
 }
 
+// WillTopic returns will topic and whether will is set
+func (msg *Connect) WillTopic() (string, bool) {
+	if !msg.willFlag() {
+		return "", false
+	}
+
+	return msg.will.topic, true
+}
+
+// WillPayload returns will message and whether will is set
+func (msg *Connect) WillPayload() ([]byte, bool) {
+	if !msg.willFlag() {
+		return nil, false
+	}
+
+	return msg.will.message, true
+}
+
 // ResetWill reset will state of message
 func (msg *Connect) ResetWill() {
 	msg.connectFlags &= ^maskConnFlagWill
@@ -200,6 +230,81 @@ func (msg *Connect) SetCredentials(u []byte, p []byte) error {
 	return nil
 }
 
+// RequestResponseInformation returns whether client requests response information in CONNACK
+// and whether property is present. If not present value defaults to false
+func (msg *Connect) RequestResponseInformation() (bool, bool) {
+	return msg.boolProperty(PropertyRequestResponseInfo, false)
+}
+
+// SetRequestResponseInformation set v5 Request Response Information property
+func (msg *Connect) SetRequestResponseInformation(v bool) error {
+	return msg.setBoolProperty(PropertyRequestResponseInfo, v)
+}
+
+// RequestProblemInformation returns whether server may send reason string and user properties
+// on failures and whether property is present. If not present value defaults to true
+func (msg *Connect) RequestProblemInformation() (bool, bool) {
+	return msg.boolProperty(PropertyRequestProblemInfo, true)
+}
+
+// SetRequestProblemInformation set v5 Request Problem Information property
+func (msg *Connect) SetRequestProblemInformation(v bool) error {
+	return msg.setBoolProperty(PropertyRequestProblemInfo, v)
+}
+
+// ReceiveMaximum returns v5 Receive Maximum client is willing to process concurrently
+// and whether property is present. If not present value defaults to 65535
+func (msg *Connect) ReceiveMaximum() (uint16, bool) {
+	return msg.receiveMaximum()
+}
+
+// SetReceiveMaximum set v5 Receive Maximum. Value of 0 is not allowed
+func (msg *Connect) SetReceiveMaximum(v uint16) error {
+	return msg.setReceiveMaximum(v)
+}
+
+// AuthenticationMethod returns v5 Authentication Method used for extended authentication
+// and whether it is set
+func (msg *Connect) AuthenticationMethod() (string, bool) {
+	if prop := msg.PropertyGet(PropertyAuthMethod); prop != nil {
+		if v, err := prop.AsString(); err == nil {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// SetAuthenticationMethod set v5 Authentication Method
+func (msg *Connect) SetAuthenticationMethod(v string) error {
+	if !utf8.ValidString(v) {
+		return ErrInvalidUtf8
+	}
+
+	return msg.PropertySet(PropertyAuthMethod, v)
+}
+
+// AuthenticationData returns v5 Authentication Data and whether it is set
+func (msg *Connect) AuthenticationData() ([]byte, bool) {
+	if prop := msg.PropertyGet(PropertyAuthData); prop != nil {
+		if v, err := prop.AsBinary(); err == nil {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// SetAuthenticationData set v5 Authentication Data
+// v5.0 [MQTT-3.1.2.11.10] it is a Protocol Error to include Authentication Data without Authentication Method
+func (msg *Connect) SetAuthenticationData(v []byte) error {
+	if _, ok := msg.AuthenticationMethod(); !ok {
+		return ErrInvalidArgs
+	}
+
+	return msg.PropertySet(PropertyAuthData, v)
+}
+
 // willFlag returns the bit that specifies whether a Will Message should be stored
 // on the server. If the Will Flag is set to 1 this indicates that, if the Connect
 // request is accepted, a Will Message MUST be stored on the Server and associated
@@ -449,6 +554,16 @@ func (msg *Connect) decodeMessage(from []byte) (int, error) {
 		}
 		offset += n
 
+		// will topic is topic name thus must not contain wildcards
+		if len(buf) == 0 || !ValidTopic(string(buf)) {
+			rejectCode := CodeRefusedServerUnavailable
+			if msg.version == ProtocolV50 {
+				rejectCode = CodeInvalidTopicName
+			}
+
+			return offset, rejectCode
+		}
+
 		msg.will.topic = string(buf)
 
 		// V3.1.1 [3.1.3.3]
@@ -565,3 +565,196 @@ func TestConnectDecodeEncodeEquiv(t *testing.T) {
 	require.NoError(t, err, "Error decoding message.")
 	require.Equal(t, len(msgBytes), n3, "Error decoding message.")
 }
+
+func TestConnectRequestInformation(t *testing.T) {
+	msg := newTestConnect(t, ProtocolV50)
+
+	v, ok := msg.RequestResponseInformation()
+	require.False(t, ok)
+	require.False(t, v)
+
+	v, ok = msg.RequestProblemInformation()
+	require.False(t, ok)
+	require.True(t, v)
+
+	for _, c := range []struct{ resp, problem bool }{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		msg = newTestConnect(t, ProtocolV50)
+		require.NoError(t, msg.SetClientID([]byte("volantmq")))
+		require.NoError(t, msg.SetRequestResponseInformation(c.resp))
+		require.NoError(t, msg.SetRequestProblemInformation(c.problem))
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+
+		m, n, err := Decode(ProtocolV50, buf)
+		require.NoError(t, err)
+		require.Equal(t, len(buf), n)
+
+		decoded, ok := m.(*Connect)
+		require.True(t, ok, "Couldn't cast message type")
+
+		v, ok = decoded.RequestResponseInformation()
+		require.True(t, ok)
+		require.Equal(t, c.resp, v)
+
+		v, ok = decoded.RequestProblemInformation()
+		require.True(t, ok)
+		require.Equal(t, c.problem, v)
+	}
+
+	msg = newTestConnect(t, ProtocolV311)
+	require.EqualError(t, msg.SetRequestResponseInformation(true), ErrNotSupported.Error())
+}
+
+func TestConnectAuthentication(t *testing.T) {
+	msg := newTestConnect(t, ProtocolV50)
+	require.NoError(t, msg.SetClientID([]byte("volantmq")))
+
+	_, ok := msg.AuthenticationMethod()
+	require.False(t, ok)
+
+	require.EqualError(t, msg.SetAuthenticationData([]byte{1, 2, 3}), ErrInvalidArgs.Error())
+
+	require.NoError(t, msg.SetAuthenticationMethod("SCRAM-SHA-1"))
+	require.NoError(t, msg.SetAuthenticationData([]byte{1, 2, 3}))
+
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+
+	m, n, err := Decode(ProtocolV50, buf)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+
+	decoded, ok := m.(*Connect)
+	require.True(t, ok, "Couldn't cast message type")
+
+	method, ok := decoded.AuthenticationMethod()
+	require.True(t, ok)
+	require.Equal(t, "SCRAM-SHA-1", method)
+
+	data, ok := decoded.AuthenticationData()
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2, 3}, data)
+}
+
+func TestConnectCleanStart(t *testing.T) {
+	for _, clean := range []bool{true, false} {
+		var flags byte
+		if clean {
+			flags = maskConnFlagClean
+		}
+
+		msgBytes := []byte{
+			byte(CONNECT << 4),
+			16,
+			0, // Length MSB (0)
+			4, // Length LSB (4)
+			'M', 'Q', 'T', 'T',
+			5,     // Protocol level 5
+			flags, // connect flags
+			0,     // Keep Alive MSB (0)
+			10,    // Keep Alive LSB (10)
+			0,     // properties
+			0,     // Client ID MSB (0)
+			3,     // Client ID LSB (3)
+			'c', 'i', 'd',
+		}
+
+		m, n, err := Decode(ProtocolV50, msgBytes)
+		require.NoError(t, err)
+		require.Equal(t, len(msgBytes), n)
+
+		msg, ok := m.(*Connect)
+		require.True(t, ok, "Couldn't cast message type")
+		require.Equal(t, clean, msg.CleanStart())
+		require.Equal(t, clean, msg.IsClean())
+	}
+}
+
+func TestConnectWillTopicPayload(t *testing.T) {
+	msg := newTestConnect(t, ProtocolV311)
+
+	_, ok := msg.WillTopic()
+	require.False(t, ok)
+
+	_, ok = msg.WillPayload()
+	require.False(t, ok)
+
+	require.EqualError(t, msg.SetWill("will/+", []byte("bye"), QoS1, false), ErrInvalidTopic.Error())
+	require.EqualError(t, msg.SetWill("will/#", []byte("bye"), QoS1, false), ErrInvalidTopic.Error())
+
+	require.NoError(t, msg.SetWill("will/client", []byte("bye"), QoS1, false))
+
+	topic, ok := msg.WillTopic()
+	require.True(t, ok)
+	require.Equal(t, "will/client", topic)
+
+	payload, ok := msg.WillPayload()
+	require.True(t, ok)
+	require.Equal(t, []byte("bye"), payload)
+
+	msgBytes := []byte{
+		byte(CONNECT << 4),
+		25,
+		0, // Length MSB (0)
+		4, // Length LSB (4)
+		'M', 'Q', 'T', 'T',
+		4,  // Protocol level 4
+		14, // connect flags 00001110, will QoS = 01
+		0,  // Keep Alive MSB (0)
+		10, // Keep Alive LSB (10)
+		0,  // Client ID MSB (0)
+		3,  // Client ID LSB (3)
+		'c', 'i', 'd',
+		0, // Will Topic MSB (0)
+		3, // Will Topic LSB (3)
+		'a', '/', '+',
+		0, // Will Message MSB (0)
+		3, // Will Message LSB (3)
+		'b', 'y', 'e',
+	}
+
+	_, _, err := Decode(ProtocolV311, msgBytes)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+	msgBytes[len(msgBytes)-6] = 'b'
+	m, _, err := Decode(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+
+	decoded, ok := m.(*Connect)
+	require.True(t, ok, "Couldn't cast message type")
+
+	topic, ok = decoded.WillTopic()
+	require.True(t, ok)
+	require.Equal(t, "a/b", topic)
+}
+
+func TestConnectReceiveMaximum(t *testing.T) {
+	msg := newTestConnect(t, ProtocolV50)
+	require.NoError(t, msg.SetClientID([]byte("volantmq")))
+
+	v, ok := msg.ReceiveMaximum()
+	require.False(t, ok)
+	require.Equal(t, uint16(65535), v)
+
+	require.EqualError(t, msg.SetReceiveMaximum(0), ErrInvalidArgs.Error())
+	require.NoError(t, msg.SetReceiveMaximum(20))
+
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+
+	m, _, err := Decode(ProtocolV50, buf)
+	require.NoError(t, err)
+
+	decoded, ok := m.(*Connect)
+	require.True(t, ok, "Couldn't cast message type")
+
+	v, ok = decoded.ReceiveMaximum()
+	require.True(t, ok)
+	require.Equal(t, uint16(20), v)
+}
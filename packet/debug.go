@@ -0,0 +1,7 @@
+//go:build debug
+// +build debug
+
+package packet
+
+// debugAssertions enables internal consistency checks, build with -tags debug
+const debugAssertions = true
@@ -0,0 +1,45 @@
+//go:build debug
+// +build debug
+
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodePacketIDAssertions(t *testing.T) {
+	m, err := New(ProtocolV311, PUBACK)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Ack)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(0)
+	require.Panics(t, func() {
+		Encode(msg) // nolint: errcheck
+	})
+
+	msg.SetPacketID(0x0102)
+	require.Panics(t, func() {
+		msg.encodePacketID(make([]byte, 1))
+	})
+
+	buf := make([]byte, 2)
+	require.Equal(t, 2, msg.encodePacketID(buf))
+	require.Equal(t, []byte{0x01, 0x02}, buf)
+
+	m, err = New(ProtocolV311, PUBLISH)
+	require.NoError(t, err)
+
+	pub, ok := m.(*Publish)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, pub.Set("a/b", []byte("data"), QoS1, false, false))
+	pub.SetPacketID(0)
+	require.NotPanics(t, func() {
+		_, err = Encode(pub)
+	})
+	require.NoError(t, err)
+}
@@ -33,6 +33,15 @@ func (msg *Disconnect) ReasonCode() ReasonCode {
 	return msg.reasonCode
 }
 
+// MinProtocolVersion returns ProtocolV50 if reason code differs from success or properties are set
+func (msg *Disconnect) MinProtocolVersion() ProtocolVersion {
+	if msg.reasonCode != CodeSuccess {
+		return ProtocolV50
+	}
+
+	return msg.header.MinProtocolVersion()
+}
+
 // SetReasonCode set disconnect reason
 func (msg *Disconnect) SetReasonCode(c ReasonCode) {
 	msg.reasonCode = c
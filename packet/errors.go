@@ -1,5 +1,9 @@
 package packet
 
+import (
+	"strconv"
+)
+
 // Error errors
 type Error byte
 
@@ -51,8 +55,71 @@ const (
 	ErrInvalidUtf8
 	ErrNotSupported
 	ErrProtocolInvalidName
+	// ErrReturnCodesCountMismatch amount of return codes does not match amount of subscriptions
+	ErrReturnCodesCountMismatch
+	// ErrTopicLengthExceeded topic is longer than configured limit
+	ErrTopicLengthExceeded
+	// ErrChecksumMismatch record checksum does not match its content
+	ErrChecksumMismatch
+	// ErrMisplacedMultiLevelWildcard multi-level wildcard is not last or does not occupy entire level
+	ErrMisplacedMultiLevelWildcard
+	// ErrMisplacedSingleLevelWildcard single-level wildcard does not occupy entire level
+	ErrMisplacedSingleLevelWildcard
+	// ErrPacketTooLarge packet size exceeds allowed maximum
+	ErrPacketTooLarge
 )
 
+// DecodeError decode failure along with packet type and offset in the buffer where it happened
+type DecodeError struct {
+	Type   Type
+	Offset int
+	Err    error
+}
+
+// Error returns cause of the failure with packet type and offset
+func (e *DecodeError) Error() string {
+	return e.Type.Name() + ": " + e.Err.Error() + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// Unwrap returns cause of the failure
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// SubscriptionError invalid subscription along with its position in the batch
+type SubscriptionError struct {
+	Index int
+	Topic string
+	Err   error
+}
+
+// Error returns cause of the failure with subscription index and topic
+func (e *SubscriptionError) Error() string {
+	return "subscription " + strconv.Itoa(e.Index) + " (" + e.Topic + "): " + e.Err.Error()
+}
+
+// Unwrap returns cause of the failure
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// QoSError invalid requested QoS of subscription met during decode
+type QoSError struct {
+	QoS   QosType
+	Topic string
+	Err   error
+}
+
+// Error returns cause of the failure with requested QoS and topic
+func (e *QoSError) Error() string {
+	return "invalid requested QoS " + strconv.Itoa(int(e.QoS)) + " for topic " + strconv.Quote(e.Topic)
+}
+
+// Unwrap returns cause of the failure
+func (e *QoSError) Unwrap() error {
+	return e.Err
+}
+
 // Error returns the corresponding error string for the ConnAckCode
 func (e Error) Error() string {
 	switch e {
@@ -102,6 +169,18 @@ func (e Error) Error() string {
 		return "String is not UTF8"
 	case ErrInvalidProtocolVersion:
 		return "Invalid protocol name"
+	case ErrReturnCodesCountMismatch:
+		return "Return codes count does not match subscriptions count"
+	case ErrTopicLengthExceeded:
+		return "Topic length exceeds configured limit"
+	case ErrChecksumMismatch:
+		return "Checksum mismatch"
+	case ErrMisplacedMultiLevelWildcard:
+		return "Multi-level wildcard must be last and occupy entire level"
+	case ErrMisplacedSingleLevelWildcard:
+		return "Single-level wildcard must occupy entire level"
+	case ErrPacketTooLarge:
+		return "Packet size exceeds maximum"
 	}
 
 	return "Unknown error"
@@ -0,0 +1,8 @@
+package packet
+
+// UnregisterType removes factory registered with RegisterType, for external tests only
+func UnregisterType(v ProtocolVersion, t Type) {
+	customTypes.Lock()
+	delete(customTypes.factories[v], t)
+	customTypes.Unlock()
+}
@@ -13,12 +13,14 @@ type header struct {
 		size   sizeCallback
 	}
 
-	properties property
-	packetID   []byte
-	remLen     int32
-	mFlags     byte
-	mType      Type
-	version    ProtocolVersion
+	properties  property
+	packetID    []byte
+	rawRemLen   []byte
+	remLen      int32
+	mFlags      byte
+	mType       Type
+	version     ProtocolVersion
+	passThrough bool
 }
 
 const (
@@ -81,14 +83,29 @@ func (h *header) Flags() byte {
 }
 
 // RemainingLength returns the length of the non-fixed-header part of the message.
+// It is computed from current content, thus is valid for built and modified messages as well
 func (h *header) RemainingLength() int32 {
-	return h.remLen
+	// bare header without content reports remaining length seen during decode
+	if h.cb.size == nil {
+		return h.remLen
+	}
+
+	return int32(h.cb.size())
 }
 
 func (h *header) Version() ProtocolVersion {
 	return h.version
 }
 
+// MinProtocolVersion returns ProtocolV50 if message carries any V5.0 only data, ProtocolV311 otherwise
+func (h *header) MinProtocolVersion() ProtocolVersion {
+	if h.mType == AUTH || len(h.properties.properties) > 0 {
+		return ProtocolV50
+	}
+
+	return ProtocolV311
+}
+
 func (h *header) ID() (IDType, error) {
 	if len(h.packetID) == 0 {
 		return 0, ErrNotSet
@@ -97,8 +114,12 @@ func (h *header) ID() (IDType, error) {
 	return IDType(binary.BigEndian.Uint16(h.packetID)), nil
 }
 
+// Encode message into to. Encoded bytes are not cached, each call encodes current state of the message
+// so there is no cached buffer which might get stale after modifications
 func (h *header) Encode(to []byte) (int, error) {
-	expectedSize, err := h.Size()
+	ml := h.cb.size()
+
+	expectedSize, err := h.sizeOf(ml)
 	if err != nil {
 		return 0, err
 	}
@@ -107,18 +128,89 @@ func (h *header) Encode(to []byte) (int, error) {
 		return expectedSize, ErrInsufficientBufferSize
 	}
 
+	offset, err := h.EncodeHeader(to, int32(ml))
+	if err != nil {
+		return offset, err
+	}
+
+	var n int
+
+	n, err = h.cb.encode(to[offset:])
+	offset += n
+	return offset, err
+}
+
+// EncodeHeader writes fixed header with given remaining length
+// It allows to write header separately from the variable header and payload
+func (h *header) EncodeHeader(to []byte, remLen int32) (int, error) {
+	if remLen > maxRemainingLength || remLen < 0 {
+		return 0, ErrInvalidLength
+	}
+
+	expectedSize := 1 + h.remLenSize(remLen)
+	if expectedSize > len(to) {
+		return expectedSize, ErrInsufficientBufferSize
+	}
+
 	offset := 0
 
 	to[offset] = byte(h.mType<<offsetPacketType) | h.mFlags
 	offset++
 
-	offset += binary.PutUvarint(to[offset:], uint64(h.remLen))
+	if h.rawRemLenMatches(remLen) {
+		offset += copy(to[offset:], h.rawRemLen)
+	} else {
+		offset += binary.PutUvarint(to[offset:], uint64(remLen))
+	}
+
+	return offset, nil
+}
 
-	var n int
+// CheckRemainingLength verifies remaining length computed from current content of the message can be sent.
+// It returns ErrInvalidLength if length exceeds protocol maximum or limit set for the packet type
+func (h *header) CheckRemainingLength() error {
+	ml := h.cb.size()
+	if ml < 0 || ml > int(maxRemainingLength) {
+		return ErrInvalidLength
+	}
 
-	n, err = h.cb.encode(to[offset:])
-	offset += n
-	return offset, err
+	if max, ok := remainingLengthLimit(h.mType); ok && int32(ml) > max {
+		return ErrInvalidLength
+	}
+
+	return nil
+}
+
+// Validate checks message content is legal to be put on the wire
+// Packets without content constraints accept any state
+func (h *header) Validate() error {
+	return nil
+}
+
+// MarshalBinary encode message into newly allocated buffer of exact size
+// It implements encoding.BinaryMarshaler
+func (h *header) MarshalBinary() ([]byte, error) {
+	sz, err := h.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, sz)
+	if _, err = h.Encode(buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// EncodeInto encode message into arena at given offset
+// it allows to batch many messages into single pre-allocated buffer
+func (h *header) EncodeInto(arena []byte, offset int) (int, error) {
+	if offset < 0 || offset > len(arena) {
+		return 0, ErrInvalidArgs
+	}
+
+	return h.Encode(arena[offset:])
 }
 
 func (h *header) SetVersion(v ProtocolVersion) {
@@ -127,13 +219,7 @@ func (h *header) SetVersion(v ProtocolVersion) {
 
 // Size of message
 func (h *header) Size() (int, error) {
-	ml := h.cb.size()
-
-	if err := h.setRemainingLength(int32(ml)); err != nil {
-		return 0, err
-	}
-
-	return h.size() + ml, nil
+	return h.sizeOf(h.cb.size())
 }
 
 func (h *header) PropertyGet(id PropertyID) PropertyToType {
@@ -162,49 +248,170 @@ func (h *header) PropertyForEach(f func(PropertyID, PropertyToType)) error {
 	return nil
 }
 
-func (h *header) setPacketID(id IDType) {
-	if len(h.packetID) == 0 {
-		h.packetID = make([]byte, 2)
+// boolProperty returns value of byte property holding boolean and whether property is present
+// def is returned if property not present
+func (h *header) boolProperty(id PropertyID, def bool) (bool, bool) {
+	if prop := h.PropertyGet(id); prop != nil {
+		if v, err := prop.AsByte(); err == nil {
+			return v == 1, true
+		}
+	}
+
+	return def, false
+}
+
+func (h *header) setBoolProperty(id PropertyID, v bool) error {
+	var val byte
+	if v {
+		val = 1
 	}
+
+	return h.PropertySet(id, val)
+}
+
+// receiveMaximum returns v5 Receive Maximum and whether it is present
+// if not present value defaults to 65535
+func (h *header) receiveMaximum() (uint16, bool) {
+	if prop := h.PropertyGet(PropertyReceiveMaximum); prop != nil {
+		if v, err := prop.AsShort(); err == nil {
+			return v, true
+		}
+	}
+
+	return 65535, false
+}
+
+func (h *header) setReceiveMaximum(v uint16) error {
+	// v5.0 [MQTT-3.1.2.11.3] it is a Protocol Error to include value of 0
+	if v == 0 {
+		return ErrInvalidArgs
+	}
+
+	return h.PropertySet(PropertyReceiveMaximum, v)
+}
+
+// userProperties returns v5 user properties in order they were added or decoded
+func (h *header) userProperties() []StringPair {
+	if prop := h.PropertyGet(PropertyUserProperty); prop != nil {
+		if v, err := prop.AsStringPairs(); err == nil {
+			res := make([]StringPair, len(v))
+			copy(res, v)
+			return res
+		}
+	}
+
+	return nil
+}
+
+// addUserProperty appends v5 user property keeping duplicates
+func (h *header) addUserProperty(key, value string) error {
+	// [MQTT-1.5.4-1] [MQTT-1.5.4-2]
+	if len(key) > 65535 || len(value) > 65535 || !validTopicEncoding(key) || !validTopicEncoding(value) {
+		return ErrInvalidArgs
+	}
+
+	pairs := append(h.userProperties(), StringPair{K: key, V: value})
+
+	return h.PropertySet(PropertyUserProperty, pairs)
+}
+
+func (h *header) propertyDelete(id PropertyID) error {
+	if h.version != ProtocolV50 {
+		return ErrNotSupported
+	}
+
+	h.properties.del(id)
+
+	return nil
+}
+
+func (h *header) setPacketID(id IDType) {
+	h.allocPacketID()
 	binary.BigEndian.PutUint16(h.packetID, uint16(id))
 }
 
 func (h *header) decodePacketID(src []byte) int {
+	h.allocPacketID()
+
+	return copy(h.packetID, src)
+}
+
+// allocPacketID reuses packet ID buffer kept by reset if any
+func (h *header) allocPacketID() {
 	if len(h.packetID) == 0 {
-		h.packetID = make([]byte, 2)
+		if cap(h.packetID) >= 2 {
+			h.packetID = h.packetID[:2]
+		} else {
+			h.packetID = make([]byte, 2)
+		}
 	}
+}
 
-	return copy(h.packetID, src)
+// reset clears header state of decoded or built message keeping type, version and allocated buffers
+func (h *header) reset() {
+	h.packetID = h.packetID[:0]
+	h.rawRemLen = nil
+	h.remLen = 0
+	h.mFlags = h.mType.DefaultFlags()
+	h.passThrough = false
+	h.properties.reset()
 }
 
 func (h *header) encodePacketID(dst []byte) int {
-	return copy(dst, h.packetID)
-}
+	n := copy(dst, h.packetID)
 
-// setRemainingLength sets the length of the non-fixed-header part of the message.
-// It returns error if the length is greater than 268435455, which is the max
-// message length as defined by the MQTT spec.
-func (h *header) setRemainingLength(remLen int32) error {
-	if remLen > maxRemainingLength || remLen < 0 {
-		return ErrInvalidLength
-	}
+	if debugAssertions {
+		// encoders check buffer size beforehand, thus packet ID is never truncated
+		if n != len(h.packetID) {
+			panic("packet ID truncated on encode")
+		}
 
-	h.remLen = remLen
+		// [MQTT-2.3.1-1] packets carrying packet ID must have non-zero one
+		// PUBLISH excluded as queued messages are persisted with placeholder ID 0 until sent
+		if n == 2 && h.mType != PUBLISH && h.packetID[0] == 0 && h.packetID[1] == 0 {
+			panic("packet ID 0 encoded")
+		}
+	}
 
-	return nil
+	return n
 }
 
 func (h *header) getHeader() *header {
 	return h
 }
 
-// size of header
-// this function must be invoked after successful call to setRemainingLength
-func (h *header) size() int {
+// sizeOf returns size of whole message with given remaining length
+// it does not modify message thus Size and Encode are safe to call concurrently
+func (h *header) sizeOf(remLen int) (int, error) {
+	if remLen > int(maxRemainingLength) || remLen < 0 {
+		return 0, ErrInvalidLength
+	}
+
 	// message type and flags byte
 	total := 1
 
-	return total + uvarintCalc(uint32(h.remLen))
+	return total + h.remLenSize(int32(remLen)) + remLen, nil
+}
+
+// remLenSize returns amount of bytes remaining length occupies on the wire
+func (h *header) remLenSize(remLen int32) int {
+	if h.rawRemLenMatches(remLen) {
+		return len(h.rawRemLen)
+	}
+
+	return uvarintCalc(uint32(remLen))
+}
+
+// rawRemLenMatches check if remaining length preserved in pass-through decode can be reused
+// it is dropped silently once message is modified and its length changes
+func (h *header) rawRemLenMatches(remLen int32) bool {
+	if len(h.rawRemLen) == 0 {
+		return false
+	}
+
+	v, _ := uvarint(h.rawRemLen)
+
+	return int32(v) == remLen
 }
 
 // setType sets the message type of this message. It also correctly sets the
@@ -250,13 +457,43 @@ func (h *header) decode(from []byte) (int, error) {
 	offset++
 
 	remLen, m := uvarint(from[offset:])
-	if m <= 0 {
+	if m == 0 {
 		return offset, ErrInsufficientDataSize
+	} else if m < 0 {
+		// [MQTT-1.5.5] remaining length longer than 4 bytes
+		rejectCode := CodeRefusedServerUnavailable
+		if h.version == ProtocolV50 {
+			rejectCode = CodeMalformedPacket
+		}
+		return offset, rejectCode
+	}
+
+	// v5 [MQTT-1.5.5] variable byte integer must use minimum number of bytes
+	// pass-through decode keeps non-minimal encoding to re-emit it as is
+	if m != uvarintCalc(remLen) {
+		if !h.passThrough {
+			rejectCode := CodeRefusedServerUnavailable
+			if h.version == ProtocolV50 {
+				rejectCode = CodeMalformedPacket
+			}
+			return offset, rejectCode
+		}
+
+		h.rawRemLen = make([]byte, m)
+		copy(h.rawRemLen, from[offset:offset+m])
 	}
 
 	offset += m
 	h.remLen = int32(remLen)
 
+	if max, ok := remainingLengthLimit(h.mType); ok && h.remLen > max {
+		rejectCode := CodeRefusedServerUnavailable
+		if h.version == ProtocolV50 {
+			rejectCode = CodePacketTooLarge
+		}
+		return offset, rejectCode
+	}
+
 	// verify if buffer has enough space for whole message
 	// if not return expected size
 	if int(h.remLen) > len(from[offset:]) {
@@ -281,15 +518,17 @@ func (h *header) decode(from []byte) (int, error) {
 //	n  < 0: value larger than 32 bits (overflow)
 //              and -n is the number of bytes read
 //
+// [MQTT-1.5.5] variable byte integer takes at most 4 bytes, fifth byte is overflow
 // copied from binary.Uvariant
 func uvarint(buf []byte) (uint32, int) {
 	var x uint32
 	var s uint
 	for i, b := range buf {
+		if i == maxVBILen {
+			return 0, -(i + 1) // overflow
+		}
+
 		if b < 0x80 {
-			if i > 4 || i == 4 && b > 1 {
-				return 0, -(i + 1) // overflow
-			}
 			return x | uint32(b)<<s, i + 1
 		}
 		x |= uint32(b&0x7f) << s
@@ -23,15 +23,15 @@ import (
 func TestMessageHeaderFields(t *testing.T) {
 	header := &header{}
 
-	header.setRemainingLength(33) // nolint: errcheck
+	header.remLen = 33
 
 	require.Equal(t, int32(33), header.RemainingLength())
 
-	err := header.setRemainingLength(268435456)
+	_, err := header.sizeOf(268435456)
 
 	require.Error(t, err)
 
-	err = header.setRemainingLength(-1)
+	_, err = header.sizeOf(-1)
 
 	require.Error(t, err)
 
@@ -114,9 +114,10 @@ func TestMessageHeaderEncode1(t *testing.T) {
 
 	//require.NoError(t, err)
 
-	err := header.setRemainingLength(321)
+	sz, err := header.sizeOf(321)
 
 	require.NoError(t, err)
+	require.Equal(t, 324, sz)
 
 	//buf := make([]byte, 3)
 	//n, err := header.encode(buf)
@@ -148,9 +149,10 @@ func TestMessageHeaderEncode3(t *testing.T) {
 
 	//require.NoError(t, err)
 
-	err := header.setRemainingLength(maxRemainingLength)
+	sz, err := header.sizeOf(int(maxRemainingLength))
 
 	require.NoError(t, err)
+	require.Equal(t, 5+int(maxRemainingLength), sz)
 
 	//buf := make([]byte, 5)
 	//n, err := header.encode(buf)
@@ -191,3 +193,109 @@ func TestMessageHeaderEncode4(t *testing.T) {
 	require.Equal(t, 0, sz)
 	require.EqualError(t, ErrInvalidLength, err.Error())
 }
+
+func TestMessageHeaderEncodeHeader(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(7)
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+
+	full, err := Encode(msg)
+	require.NoError(t, err)
+
+	hdr := make([]byte, 5)
+	n, err := msg.EncodeHeader(hdr, msg.RemainingLength())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	body := full[n:]
+	require.Equal(t, int(msg.RemainingLength()), len(body))
+	require.Equal(t, full, append(hdr[:n], body...))
+
+	// built message reports remaining length of its current content
+	require.NoError(t, msg.AddTopic("a", SubscriptionOptions(QoS0)))
+	require.Equal(t, int32(len(body)+4), msg.RemainingLength())
+
+	n, err = msg.EncodeHeader(hdr, 16384)
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(SUBSCRIBE<<4) | 2, 0x80, 0x80, 0x01}, hdr[:n])
+
+	n, err = msg.EncodeHeader(hdr[:2], 16384)
+	require.EqualError(t, err, ErrInsufficientBufferSize.Error())
+	require.Equal(t, 4, n)
+
+	_, err = msg.EncodeHeader(hdr, maxRemainingLength+1)
+	require.EqualError(t, err, ErrInvalidLength.Error())
+}
+
+func TestMessageHeaderNonMinimalRemainingLength(t *testing.T) {
+	msgBytes := []byte{
+		byte(UNSUBACK << 4),
+		0x82, 0x00, // remaining length 2 encoded with 2 bytes
+		0, // packet ID MSB
+		7, // packet ID LSB
+	}
+
+	_, _, err := Decode(ProtocolV311, msgBytes)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+	_, _, err = Decode(ProtocolV50, msgBytes)
+	require.EqualError(t, err, CodeMalformedPacket.Error())
+
+	m, n, err := DecodePassThrough(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+	require.Equal(t, len(msgBytes), n)
+
+	buf, err := Encode(m)
+	require.NoError(t, err)
+	require.Equal(t, msgBytes, buf)
+
+	// message changed its length thus minimal encoding is used
+	m, _, err = DecodePassThrough(ProtocolV311, []byte{byte(SUBSCRIBE<<4) | 2, 0x86, 0x00, 0, 7, 0, 1, 'a', 1})
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	require.NoError(t, msg.AddTopic("b", SubscriptionOptions(QoS0)))
+
+	buf, err = Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(SUBSCRIBE<<4) | 2, 10, 0, 7, 0, 1, 'a', 1, 0, 1, 'b', 0}, buf)
+}
+
+func TestPacketIDEndianness(t *testing.T) {
+	m, err := New(ProtocolV311, PUBACK)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Ack)
+	require.True(t, ok, "Couldn't cast message type")
+	msg.SetPacketID(0x0102)
+
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(PUBACK << 4), 2, 0x01, 0x02}, buf)
+}
+
+func TestMessageHeaderCheckRemainingLength(t *testing.T) {
+	type testMessage struct {
+		header
+	}
+
+	var msg testMessage
+
+	msg.cb.size = func() int {
+		return int(maxRemainingLength)
+	}
+
+	require.NoError(t, msg.CheckRemainingLength())
+
+	msg.cb.size = func() int {
+		return int(maxRemainingLength) + 1
+	}
+
+	require.EqualError(t, msg.CheckRemainingLength(), ErrInvalidLength.Error())
+}
@@ -0,0 +1,96 @@
+package packet
+
+import (
+	"sync"
+)
+
+// PacketIDAllocator provides packet identifiers unique within a session
+// Each session is expected to own an allocator so ID spaces of different sessions never interfere
+type PacketIDAllocator interface {
+	// Next returns next packet ID not in flight and marks it in use
+	// false returned if all IDs are in use
+	Next() (IDType, bool)
+
+	// Acquire marks given packet ID in use, e.g. when restoring persisted in-flight messages
+	// false returned if ID is 0 or already in use
+	Acquire(id IDType) bool
+
+	// Release marks packet ID as free when its flow is complete
+	Release(id IDType)
+}
+
+type ringIDAllocator struct {
+	lock  sync.Mutex
+	last  IDType
+	count int
+	inUse [(0xFFFF + 1) / 64]uint64
+}
+
+var _ PacketIDAllocator = (*ringIDAllocator)(nil)
+
+// NewPacketIDAllocator allocate ring based packet ID allocator
+// IDs are handed out in ascending order wrapping after 65535 and skipping 0 and IDs still in flight
+func NewPacketIDAllocator() PacketIDAllocator {
+	return &ringIDAllocator{}
+}
+
+// Next returns next packet ID not in flight
+func (a *ringIDAllocator) Next() (IDType, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// [MQTT-2.3.1-1] packet ID 0 is not allowed
+	if a.count == 0xFFFF {
+		return 0, false
+	}
+
+	id := a.last
+
+	for {
+		id++
+		if id != 0 && !a.isSet(id) {
+			break
+		}
+	}
+
+	a.set(id)
+	a.last = id
+
+	return id, true
+}
+
+// Acquire marks packet ID in use
+func (a *ringIDAllocator) Acquire(id IDType) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if id == 0 || a.isSet(id) {
+		return false
+	}
+
+	a.set(id)
+
+	return true
+}
+
+// Release marks packet ID as free
+func (a *ringIDAllocator) Release(id IDType) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if id == 0 || !a.isSet(id) {
+		return
+	}
+
+	a.inUse[id/64] &^= 1 << (id % 64)
+	a.count--
+}
+
+func (a *ringIDAllocator) isSet(id IDType) bool {
+	return a.inUse[id/64]&(1<<(id%64)) != 0
+}
+
+func (a *ringIDAllocator) set(id IDType) {
+	a.inUse[id/64] |= 1 << (id % 64)
+	a.count++
+}
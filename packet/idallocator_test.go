@@ -0,0 +1,69 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketIDAllocatorIndependent(t *testing.T) {
+	a := NewPacketIDAllocator()
+	b := NewPacketIDAllocator()
+
+	for i := 1; i <= 3; i++ {
+		id, ok := a.Next()
+		require.True(t, ok)
+		require.Equal(t, IDType(i), id)
+	}
+
+	id, ok := b.Next()
+	require.True(t, ok)
+	require.Equal(t, IDType(1), id)
+}
+
+func TestPacketIDAllocatorSkipsInFlight(t *testing.T) {
+	a := NewPacketIDAllocator()
+
+	require.True(t, a.Acquire(2))
+	require.False(t, a.Acquire(2))
+	require.False(t, a.Acquire(0))
+
+	id, _ := a.Next()
+	require.Equal(t, IDType(1), id)
+
+	id, _ = a.Next()
+	require.Equal(t, IDType(3), id)
+
+	a.Release(2)
+	a.Release(2)
+
+	// allocator keeps going forward and reuses released IDs after wrap only
+	id, _ = a.Next()
+	require.Equal(t, IDType(4), id)
+}
+
+func TestPacketIDAllocatorWrap(t *testing.T) {
+	a := NewPacketIDAllocator()
+
+	for i := 1; i <= 0xFFFF; i++ {
+		_, ok := a.Next()
+		require.True(t, ok)
+	}
+
+	_, ok := a.Next()
+	require.False(t, ok)
+
+	a.Release(10)
+	a.Release(0xFFFF)
+
+	id, ok := a.Next()
+	require.True(t, ok)
+	require.Equal(t, IDType(10), id)
+
+	id, ok = a.Next()
+	require.True(t, ok)
+	require.Equal(t, IDType(0xFFFF), id)
+
+	_, ok = a.Next()
+	require.False(t, ok)
+}
@@ -0,0 +1,128 @@
+package packet
+
+// Matcher finds QoS of subscriptions matching topic name
+// Implementations trade generality for speed thus caller may pick one suitable for workload
+type Matcher interface {
+	// Match returns maximum QoS of subscriptions matching topic and whether there is any
+	Match(topic string) (QosType, bool)
+}
+
+type exactMatcher struct {
+	set map[string]QosType
+}
+
+var _ Matcher = (*exactMatcher)(nil)
+
+// NewExactMatcher allocate matcher over subscriptions without wildcards
+// Wildcard subscriptions are ignored, lookup takes constant time
+func NewExactMatcher(msg *Subscribe) Matcher {
+	return &exactMatcher{
+		set: msg.BuildExactSet(),
+	}
+}
+
+// Match returns QoS of subscription to topic
+func (m *exactMatcher) Match(topic string) (QosType, bool) {
+	q, ok := m.set[topic]
+	return q, ok
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	qos      QosType
+	leaf     bool
+}
+
+type trieMatcher struct {
+	root trieNode
+}
+
+var _ Matcher = (*trieMatcher)(nil)
+
+// NewTrieMatcher allocate matcher over all subscriptions including wildcards
+// Subscriptions are put into tree of topic levels so lookup depends on topic depth
+// rather than amount of subscriptions. Shared subscriptions are put by theirs topic filter
+func NewTrieMatcher(msg *Subscribe) Matcher {
+	m := &trieMatcher{}
+
+	msg.RangeTopics(func(t string, ops SubscriptionOptions) {
+		if _, filter, ok := ParseSharedSubscription(t); ok {
+			t = filter
+		}
+
+		node := &m.root
+
+		c := NewLevelCursor(t)
+		for level, ok := c.Next(); ok; level, ok = c.Next() {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+
+			next, ok := node.children[level]
+			if !ok {
+				next = &trieNode{}
+				node.children[level] = next
+			}
+
+			node = next
+		}
+
+		// subscription to same filter replaces previous one
+		node.qos = ops.QoS()
+		node.leaf = true
+	})
+
+	return m
+}
+
+// Match returns maximum QoS of subscriptions matching topic
+func (m *trieMatcher) Match(topic string) (QosType, bool) {
+	res := trieMatch{}
+
+	// [MQTT-4.7.2-1] filters starting with wildcard do not match topics starting with $
+	m.root.match(topic, !IsSystemTopic(topic), &res)
+
+	return res.qos, res.found
+}
+
+type trieMatch struct {
+	qos   QosType
+	found bool
+}
+
+func (r *trieMatch) add(n *trieNode) {
+	if n != nil && n.leaf {
+		if !r.found || n.qos > r.qos {
+			r.qos = n.qos
+		}
+		r.found = true
+	}
+}
+
+func (n *trieNode) match(topic string, wildcards bool, res *trieMatch) {
+	level, rest, more := splitTopicLevel(topic)
+
+	if wildcards {
+		// [MQTT-4.7.1-2] multi-level wildcard matches any number of levels
+		res.add(n.children[topicMultiWildcard])
+		n.matchLevel(n.children[topicSingleWildcard], rest, more, res)
+	}
+
+	n.matchLevel(n.children[level], rest, more, res)
+}
+
+func (n *trieNode) matchLevel(child *trieNode, rest string, more bool, res *trieMatch) {
+	if child == nil {
+		return
+	}
+
+	if more {
+		child.match(rest, true, res)
+		return
+	}
+
+	res.add(child)
+
+	// [MQTT-4.7.1-2] multi-level wildcard matches parent level as well
+	res.add(child.children[topicMultiWildcard])
+}
@@ -0,0 +1,77 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newMatcherSubscribe(t *testing.T) *Subscribe {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopics(
+		[]string{"sport/tennis", "sport/golf", "sport/tennis/+", "weather/#", "$SYS/uptime", "$share/g/news", "+/score", "sport/golf"},
+		[]SubscriptionOptions{
+			SubscriptionOptions(QoS1),
+			SubscriptionOptions(QoS2),
+			SubscriptionOptions(QoS2),
+			SubscriptionOptions(QoS0),
+			SubscriptionOptions(QoS1),
+			SubscriptionOptions(QoS2),
+			SubscriptionOptions(QoS1),
+			SubscriptionOptions(QoS0),
+		}))
+
+	return msg
+}
+
+func TestMatchersExactTopics(t *testing.T) {
+	msg := newMatcherSubscribe(t)
+
+	exact := NewExactMatcher(msg)
+	trie := NewTrieMatcher(msg)
+
+	for _, topic := range []string{"sport/tennis", "sport/golf", "$SYS/uptime", "news", "sport/chess"} {
+		eq, eok := exact.Match(topic)
+		tq, tok := trie.Match(topic)
+
+		require.Equal(t, eok, tok, topic)
+		require.Equal(t, eq, tq, topic)
+	}
+
+	q, ok := exact.Match("sport/golf")
+	require.True(t, ok)
+	require.Equal(t, QoS0, q)
+}
+
+func TestTrieMatcherWildcards(t *testing.T) {
+	trie := NewTrieMatcher(newMatcherSubscribe(t))
+
+	cases := []struct {
+		topic string
+		qos   QosType
+		ok    bool
+	}{
+		{"sport/tennis/player1", QoS2, true},
+		{"sport/tennis/player1/ranking", 0, false},
+		{"weather", QoS0, true},
+		{"weather/eu/de", QoS0, true},
+		{"tennis/score", QoS1, true},
+		{"$SYS/score", 0, false},
+		{"sport", 0, false},
+	}
+
+	for _, c := range cases {
+		q, ok := trie.Match(c.topic)
+		require.Equal(t, c.ok, ok, c.topic)
+		require.Equal(t, c.qos, q, c.topic)
+	}
+
+	exact := NewExactMatcher(newMatcherSubscribe(t))
+	_, ok := exact.Match("weather/eu")
+	require.False(t, ok)
+}
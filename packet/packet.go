@@ -1,13 +1,19 @@
 package packet
 
 import (
+	"bytes"
+	"encoding/binary"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
 const (
 	//maxFixedHeaderLength int    = 5
 	maxRemainingLength int32 = (256 * 1024 * 1024) - 1 // 256 MB
+
+	// maxVBILen maximum amount of bytes in variable byte integer
+	maxVBILen = 4
 )
 const (
 	//  maskHeaderType  byte = 0xF0
@@ -68,6 +74,22 @@ func (s SubscriptionOptions) RetainHandling() RetainHandling {
 	return RetainHandling((byte(s) & maskSubscriptionRetainHandling) >> offsetSubscriptionRetainHandling)
 }
 
+// NewSubscriptionOptions pack subscription options into options byte
+// No Local, Retain As Published and Retain Handling are V5.0 ONLY
+func NewSubscriptionOptions(qos QosType, nl bool, rap bool, rh RetainHandling) SubscriptionOptions {
+	ops := byte(qos)&maskSubscriptionQoS | (byte(rh)<<offsetSubscriptionRetainHandling)&maskSubscriptionRetainHandling
+
+	if nl {
+		ops |= maskSubscriptionNL
+	}
+
+	if rap {
+		ops |= maskSubscriptionRAP
+	}
+
+	return SubscriptionOptions(ops)
+}
+
 // Provider is an interface defined for all MQTT message types.
 type Provider interface {
 	// Desc returns a string description of the message type. For example, a
@@ -90,6 +112,9 @@ type Provider interface {
 	// considered invalid.
 	Encode([]byte) (int, error)
 
+	// EncodeInto writes the message bytes into arena starting at offset. It returns number of bytes written
+	EncodeInto(arena []byte, offset int) (int, error)
+
 	// Size of whole message
 	Size() (int, error)
 
@@ -99,6 +124,12 @@ type Provider interface {
 	// Version get protocol version used by message
 	Version() ProtocolVersion
 
+	// MinProtocolVersion minimal protocol version able to represent message content without loss
+	MinProtocolVersion() ProtocolVersion
+
+	// Validate checks message content is legal to be put on the wire
+	Validate() error
+
 	PropertyGet(PropertyID) PropertyToType
 
 	PropertySet(PropertyID, interface{}) error
@@ -132,6 +163,105 @@ type Provider interface {
 	setType(t Type)
 }
 
+// Factory allocates payload of vendor specific packet type
+type Factory func() VendorPayload
+
+var customTypes = struct {
+	sync.RWMutex
+	factories map[ProtocolVersion]map[Type]Factory
+}{
+	factories: make(map[ProtocolVersion]map[Type]Factory),
+}
+
+// RegisterType registers factory for packet type which is reserved by given protocol version
+// so New and Decode are able to produce vendor extension messages as *Vendor.
+// Packet types defined by the spec for given version cannot be overridden
+func RegisterType(v ProtocolVersion, t Type, f Factory) error {
+	valid, err := t.Valid(v)
+	if err != nil {
+		return err
+	}
+
+	if valid || t > AUTH {
+		return ErrInvalidMessageType
+	}
+
+	if f == nil {
+		return ErrInvalidArgs
+	}
+
+	customTypes.Lock()
+	defer customTypes.Unlock()
+
+	if _, ok := customTypes.factories[v]; !ok {
+		customTypes.factories[v] = make(map[Type]Factory)
+	}
+
+	customTypes.factories[v][t] = f
+
+	return nil
+}
+
+func customFactory(v ProtocolVersion, t Type) Factory {
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+
+	return customTypes.factories[v][t]
+}
+
+var maxRemainingLengths = struct {
+	sync.RWMutex
+	limits map[Type]int32
+	def    int32
+}{
+	limits: make(map[Type]int32),
+}
+
+// SetMaxRemainingLength limits remaining length Decode accepts for given packet type
+// so packet declaring larger length is rejected before its body is read.
+// max of 0 removes the limit
+func SetMaxRemainingLength(t Type, max int32) error {
+	if t > AUTH || max < 0 || max > maxRemainingLength {
+		return ErrInvalidArgs
+	}
+
+	maxRemainingLengths.Lock()
+	defer maxRemainingLengths.Unlock()
+
+	if max == 0 {
+		delete(maxRemainingLengths.limits, t)
+	} else {
+		maxRemainingLengths.limits[t] = max
+	}
+
+	return nil
+}
+
+// SetDefaultMaxRemainingLength limits remaining length Decode accepts for packet types
+// without own limit set by SetMaxRemainingLength. max of 0 removes the limit
+func SetDefaultMaxRemainingLength(max int32) error {
+	if max < 0 || max > maxRemainingLength {
+		return ErrInvalidArgs
+	}
+
+	maxRemainingLengths.Lock()
+	maxRemainingLengths.def = max
+	maxRemainingLengths.Unlock()
+
+	return nil
+}
+
+func remainingLengthLimit(t Type) (int32, bool) {
+	maxRemainingLengths.RLock()
+	defer maxRemainingLengths.RUnlock()
+
+	if max, ok := maxRemainingLengths.limits[t]; ok {
+		return max, true
+	}
+
+	return maxRemainingLengths.def, maxRemainingLengths.def > 0
+}
+
 // New creates a new message based on the message type. It is a shortcut to call
 // one of the New*Message functions. If an error is returned then the message type
 // is invalid.
@@ -172,12 +302,23 @@ func newMessage(v ProtocolVersion, t Type) (Provider, error) {
 	case DISCONNECT:
 		m = newDisconnect()
 	case AUTH:
-		if v != ProtocolV50 {
+		if v == ProtocolV50 {
+			m = newAuth()
+		}
+	}
+
+	if m == nil {
+		f := customFactory(v, t)
+		if f == nil {
 			return nil, ErrInvalidMessageType
 		}
-		m = newAuth()
-	default:
-		return nil, ErrInvalidMessageType
+
+		p := f()
+		if p == nil {
+			return nil, ErrInvalidMessageType
+		}
+
+		m = newVendor(p)
 	}
 
 	m.setType(t)
@@ -210,8 +351,160 @@ func Encode(p Provider) ([]byte, error) {
 	return buf, err
 }
 
+// SameWire check if both messages encode into identical bytes, including packet ID
+func SameWire(a, b Provider) (bool, error) {
+	aBuf, err := Encode(a)
+	if err != nil {
+		return false, err
+	}
+
+	bBuf, err := Encode(b)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(aBuf, bBuf), nil
+}
+
 // Decode buf into message and return Provider type
-func Decode(v ProtocolVersion, buf []byte) (msg Provider, total int, err error) {
+func Decode(v ProtocolVersion, buf []byte) (Provider, int, error) {
+	return decode(v, buf, decodeOptions{})
+}
+
+// DecodeLenient same as Decode but v5 properties known by spec and not expected in given packet type
+// are skipped instead of failing decode. Unknown property ids still fail
+func DecodeLenient(v ProtocolVersion, buf []byte) (Provider, int, error) {
+	return decode(v, buf, decodeOptions{lenient: true})
+}
+
+// DecodePassThrough same as Decode but remaining length encoded with more bytes than necessary
+// is accepted and re-emitted as is on Encode unless message length changes
+func DecodePassThrough(v ProtocolVersion, buf []byte) (Provider, int, error) {
+	return decode(v, buf, decodeOptions{passThrough: true})
+}
+
+// DecodeWithOffset same as Decode but on failure error is *DecodeError
+// which carries packet type and offset in buf where decode stopped.
+// Offset is 0 if decode of malformed packet panicked and was recovered
+func DecodeWithOffset(v ProtocolVersion, buf []byte) (Provider, int, error) {
+	msg, total, err := decode(v, buf, decodeOptions{})
+	if err != nil {
+		dErr := &DecodeError{Offset: total, Err: err}
+		if len(buf) > 0 {
+			dErr.Type = Type(buf[0] >> offsetPacketType)
+		}
+
+		err = dErr
+	}
+
+	return msg, total, err
+}
+
+// DecodeSession decodes all packets in src and sorts them by type.
+// n is amount of bytes consumed by successfully decoded packets
+func DecodeSession(v ProtocolVersion, src []byte) (subs []*Subscribe, pubs []*Publish, others []Provider, n int, err error) {
+	for n < len(src) {
+		var m Provider
+		var total int
+
+		if m, total, err = Decode(v, src[n:]); err != nil {
+			return subs, pubs, others, n, err
+		}
+
+		n += total
+
+		switch msg := m.(type) {
+		case *Subscribe:
+			subs = append(subs, msg)
+		case *Publish:
+			pubs = append(pubs, msg)
+		default:
+			others = append(others, m)
+		}
+	}
+
+	return subs, pubs, others, n, nil
+}
+
+type decodeOptions struct {
+	lenient     bool
+	passThrough bool
+}
+
+// ValidateMinimalVBI check remaining length in fixed header of the packet in buf
+// is encoded with minimal number of bytes
+// v5 [MQTT-1.5.5] overlong variable byte integer is malformed packet
+func ValidateMinimalVBI(buf []byte) error {
+	if len(buf) < 2 {
+		return ErrInsufficientBufferSize
+	}
+
+	remLen, n := uvarint(buf[1:])
+	if n == 0 {
+		return ErrInsufficientDataSize
+	} else if n < 0 || remLen > uint32(maxRemainingLength) {
+		return ErrInvalidLength
+	}
+
+	if n != uvarintCalc(remLen) {
+		return CodeMalformedPacket
+	}
+
+	return nil
+}
+
+// PeekPacketID returns packet ID of the packet in buf without decoding rest of the packet
+// Suitable for acknowledgement routing.
+// Supported types are PUBACK, PUBREC, PUBREL, PUBCOMP, SUBSCRIBE, SUBACK, UNSUBSCRIBE and UNSUBACK
+func PeekPacketID(buf []byte) (IDType, error) {
+	if len(buf) < 1 {
+		return 0, ErrInsufficientBufferSize
+	}
+
+	switch Type(buf[0] >> offsetPacketType) {
+	case PUBACK, PUBREC, PUBREL, PUBCOMP, SUBSCRIBE, SUBACK, UNSUBSCRIBE, UNSUBACK:
+	default:
+		return 0, ErrInvalidMessageType
+	}
+
+	remLen, n := uvarint(buf[1:])
+	if n <= 0 {
+		return 0, ErrInsufficientDataSize
+	}
+
+	offset := 1 + n
+
+	if remLen < 2 || len(buf) < offset+2 {
+		return 0, ErrInsufficientDataSize
+	}
+
+	return IDType(binary.BigEndian.Uint16(buf[offset:])), nil
+}
+
+// DecodeInto decode packet from buf into msg allocated by New or recycled with Reset
+// Type of the packet in buf must match type of msg
+func DecodeInto(msg Provider, buf []byte) (n int, err error) {
+	defer func() {
+		// see decode for details
+		if r := recover(); r != nil {
+			n = 0
+			err = ErrPanicDetected
+		}
+	}()
+
+	if len(buf) < 1 {
+		return 0, ErrInsufficientBufferSize
+	}
+
+	// [MQTT-2.2]
+	if Type(buf[0]>>offsetPacketType) != msg.Type() {
+		return 0, ErrInvalidMessageType
+	}
+
+	return msg.decode(buf)
+}
+
+func decode(v ProtocolVersion, buf []byte, opts decodeOptions) (msg Provider, total int, err error) {
 	defer func() {
 		// TODO: this case might be improved
 		// Panic might be provided during message decode with malformed len
@@ -245,6 +538,10 @@ func Decode(v ProtocolVersion, buf []byte) (msg Provider, total int, err error)
 		return nil, 0, err
 	}
 
+	h := msg.getHeader()
+	h.properties.lenient = opts.lenient
+	h.passThrough = opts.passThrough
+
 	if total, err = msg.decode(buf); err != nil {
 		return nil, total, err
 	}
@@ -15,6 +15,10 @@
 package packet
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -241,3 +245,493 @@ func TestMessageDecode(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, 0, n)
 }
+
+func TestCountingWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewCountingWriter(&buf)
+	require.Equal(t, int64(0), w.BytesWritten())
+
+	m, err := New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+
+	n, err := WriteTo(m, w)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, int64(2), w.BytesWritten())
+
+	m, err = New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopic("a/b", SubscriptionOptions(QoS1)))
+
+	n, err = WriteTo(msg, w)
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	require.Equal(t, int64(12), w.BytesWritten())
+	require.Equal(t, buf.Len(), int(w.BytesWritten()))
+
+	msg.packetID = nil
+	_, err = WriteTo(msg, w)
+	require.Error(t, err)
+	require.Equal(t, int64(12), w.BytesWritten())
+}
+
+// RunConcurrentRoundTrip encodes message and decodes result from many goroutines at once
+// to let race detector catch shared state modified by Encode/Size
+func RunConcurrentRoundTrip(t *testing.T, m Provider) {
+	expected, err := Encode(m)
+	require.NoError(t, err)
+
+	const workers = 16
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				buf, e := Encode(m)
+				if e != nil {
+					errs <- e
+					return
+				}
+
+				if !bytes.Equal(expected, buf) {
+					errs <- errors.New("encoded bytes differ")
+					return
+				}
+
+				var decoded Provider
+				if decoded, _, e = Decode(m.Version(), buf); e != nil {
+					errs <- e
+					return
+				}
+
+				if buf, e = Encode(decoded); e != nil || !bytes.Equal(expected, buf) {
+					errs <- errors.New("re-encoded bytes differ")
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for e := range errs {
+		require.NoError(t, e)
+	}
+}
+
+func TestConcurrentRoundTrip(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	sub.SetPacketID(10)
+	require.NoError(t, sub.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, sub.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.NoError(t, sub.PropertySet(PropertySubscriptionIdentifier, uint32(5)))
+
+	RunConcurrentRoundTrip(t, sub)
+
+	m, err = New(ProtocolV311, UNSUBACK)
+	require.NoError(t, err)
+
+	ack, ok := m.(*UnSubAck)
+	require.True(t, ok, "Couldn't cast message type")
+	ack.SetPacketID(10)
+
+	RunConcurrentRoundTrip(t, ack)
+}
+
+func TestDecodeSession(t *testing.T) {
+	var src []byte
+
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	sub.SetPacketID(1)
+	require.NoError(t, sub.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+
+	m, err = New(ProtocolV311, PUBLISH)
+	require.NoError(t, err)
+
+	pub, ok := m.(*Publish)
+	require.True(t, ok, "Couldn't cast message type")
+	require.NoError(t, pub.SetTopic("sport/tennis"))
+	pub.SetPayload([]byte("ace"))
+
+	ping, err := New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+
+	for _, p := range []Provider{sub, pub, ping, pub} {
+		buf, e := Encode(p)
+		require.NoError(t, e)
+		src = append(src, buf...)
+	}
+
+	subs, pubs, others, n, err := DecodeSession(ProtocolV311, src)
+	require.NoError(t, err)
+	require.Equal(t, len(src), n)
+	require.Equal(t, 1, len(subs))
+	require.Equal(t, 2, len(pubs))
+	require.Equal(t, 1, len(others))
+	require.Equal(t, PINGREQ, others[0].Type())
+	require.Equal(t, "sport/tennis", pubs[1].Topic())
+
+	// truncated last packet
+	subs, pubs, others, n, err = DecodeSession(ProtocolV311, src[:len(src)-1])
+	require.EqualError(t, err, ErrInsufficientDataSize.Error())
+	require.Equal(t, 1, len(subs))
+	require.Equal(t, 1, len(pubs))
+	require.Equal(t, 1, len(others))
+	pubSize, err := pub.Size()
+	require.NoError(t, err)
+	require.Equal(t, len(src)-pubSize, n)
+}
+
+func TestDecodeErrorType(t *testing.T) {
+	// PUBREL with invalid flags
+	_, _, err := DecodeWithOffset(ProtocolV50, []byte{byte(PUBREL << offsetPacketType), 2, 0, 1})
+	require.Error(t, err)
+
+	decodeErr, ok := err.(*DecodeError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, PUBREL, decodeErr.Type)
+	require.Equal(t, CodeMalformedPacket, decodeErr.Unwrap())
+	require.Equal(t, "PUBREL: "+CodeMalformedPacket.Error()+" at offset 0", err.Error())
+}
+
+func TestSameWire(t *testing.T) {
+	newSub := func(id IDType) *Subscribe {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		msg.SetPacketID(id)
+		require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+
+		return msg
+	}
+
+	same, err := SameWire(newSub(1), newSub(1))
+	require.NoError(t, err)
+	require.True(t, same)
+
+	same, err = SameWire(newSub(1), newSub(2))
+	require.NoError(t, err)
+	require.False(t, same)
+
+	noID := newSub(1)
+	noID.packetID = nil
+	_, err = SameWire(newSub(1), noID)
+	require.EqualError(t, err, ErrPackedIDZero.Error())
+}
+
+func TestPeekPacketID(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		for _, mt := range []Type{PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK} {
+			m, err := New(v, mt)
+			require.NoError(t, err)
+
+			m.(interface{ SetPacketID(IDType) }).SetPacketID(0x1234)
+
+			buf, err := Encode(m)
+			require.NoError(t, err)
+
+			id, err := PeekPacketID(buf)
+			require.NoError(t, err, mt.Name())
+			require.Equal(t, IDType(0x1234), id, mt.Name())
+		}
+
+		m, err := New(v, SUBACK)
+		require.NoError(t, err)
+
+		ack := m.(*SubAck)
+		ack.SetPacketID(0xABCD)
+		require.NoError(t, ack.AddReturnCode(ReasonCode(QoS1)))
+
+		buf, err := Encode(ack)
+		require.NoError(t, err)
+
+		id, err := PeekPacketID(buf)
+		require.NoError(t, err)
+		require.Equal(t, IDType(0xABCD), id)
+	}
+
+	_, err := PeekPacketID([]byte{byte(PINGREQ << 4), 0})
+	require.EqualError(t, err, ErrInvalidMessageType.Error())
+
+	_, err = PeekPacketID([]byte{byte(PUBACK << 4), 2, 0})
+	require.EqualError(t, err, ErrInsufficientDataSize.Error())
+
+	_, err = PeekPacketID(nil)
+	require.EqualError(t, err, ErrInsufficientBufferSize.Error())
+}
+
+func TestSetMaxRemainingLength(t *testing.T) {
+	require.EqualError(t, SetMaxRemainingLength(PINGREQ, -1), ErrInvalidArgs.Error())
+	require.EqualError(t, SetMaxRemainingLength(Type(16), 10), ErrInvalidArgs.Error())
+
+	require.NoError(t, SetMaxRemainingLength(PINGREQ, 1))
+	require.NoError(t, SetMaxRemainingLength(PUBLISH, 64))
+
+	defer func() {
+		SetMaxRemainingLength(PINGREQ, 0) // nolint: errcheck
+		SetMaxRemainingLength(PUBLISH, 0) // nolint: errcheck
+	}()
+
+	// PINGREQ declaring 2MB is rejected without waiting for the body
+	oversized := []byte{byte(PINGREQ << 4), 0x80, 0x80, 0x80, 0x01}
+
+	_, _, err := Decode(ProtocolV311, oversized)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+	_, _, err = Decode(ProtocolV50, oversized)
+	require.EqualError(t, err, CodePacketTooLarge.Error())
+
+	m, err := New(ProtocolV311, PUBLISH)
+	require.NoError(t, err)
+
+	pub := m.(*Publish)
+	require.NoError(t, pub.SetTopic("a/b"))
+	pub.SetPayload([]byte("within limit"))
+
+	buf, err := Encode(pub)
+	require.NoError(t, err)
+
+	_, _, err = Decode(ProtocolV311, buf)
+	require.NoError(t, err)
+
+	pub.SetPayload(make([]byte, 64))
+
+	buf, err = Encode(pub)
+	require.NoError(t, err)
+
+	_, _, err = Decode(ProtocolV311, buf)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+}
+
+func TestSetDefaultMaxRemainingLength(t *testing.T) {
+	require.EqualError(t, SetDefaultMaxRemainingLength(-1), ErrInvalidArgs.Error())
+	require.EqualError(t, SetDefaultMaxRemainingLength(maxRemainingLength+1), ErrInvalidArgs.Error())
+
+	require.NoError(t, SetDefaultMaxRemainingLength(1024))
+	require.NoError(t, SetMaxRemainingLength(PUBLISH, 4096))
+
+	defer func() {
+		SetDefaultMaxRemainingLength(0)   // nolint: errcheck
+		SetMaxRemainingLength(PUBLISH, 0) // nolint: errcheck
+	}()
+
+	// SUBSCRIBE declaring 2048 bytes is rejected by default limit before the body arrives
+	oversized := []byte{byte(SUBSCRIBE<<4) | 0x02, 0x80, 0x10}
+
+	_, _, err := Decode(ProtocolV311, oversized)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+	_, _, err = Decode(ProtocolV50, oversized)
+	require.EqualError(t, err, CodePacketTooLarge.Error())
+
+	// own limit of the type takes precedence
+	_, _, err = Decode(ProtocolV311, []byte{byte(PUBLISH << 4), 0x80, 0x10})
+	require.EqualError(t, err, ErrInsufficientDataSize.Error())
+
+	require.NoError(t, SetDefaultMaxRemainingLength(0))
+
+	_, _, err = Decode(ProtocolV311, oversized)
+	require.EqualError(t, err, ErrInsufficientDataSize.Error())
+}
+
+func TestDecodeRemainingLengthTooLong(t *testing.T) {
+	// five bytes of remaining length
+	for _, buf := range [][]byte{
+		{byte(PINGREQ << 4), 0x80, 0x80, 0x80, 0x80, 0x01},
+		{byte(PINGREQ << 4), 0xFF, 0xFF, 0xFF, 0xFF, 0x7F},
+		{byte(PINGREQ << 4), 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
+	} {
+		_, _, err := Decode(ProtocolV311, buf)
+		require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+		_, _, err = Decode(ProtocolV50, buf)
+		require.EqualError(t, err, CodeMalformedPacket.Error())
+	}
+
+	// four bytes with continuation bit still wait for more data
+	_, _, err := Decode(ProtocolV311, []byte{byte(PINGREQ << 4), 0x80, 0x80, 0x80, 0x80})
+	require.EqualError(t, err, ErrInsufficientDataSize.Error())
+}
+
+func TestValidateMinimalVBI(t *testing.T) {
+	minimal := map[string][]byte{
+		"0":   {byte(PINGREQ << 4), 0x00},
+		"127": {byte(PUBLISH << 4), 0x7F},
+		"128": {byte(PUBLISH << 4), 0x80, 0x01},
+		"max": {byte(PUBLISH << 4), 0xFF, 0xFF, 0xFF, 0x7F},
+	}
+
+	for name, buf := range minimal {
+		require.NoError(t, ValidateMinimalVBI(buf), name)
+	}
+
+	overlong := map[string][]byte{
+		"0":   {byte(PINGREQ << 4), 0x80, 0x00},
+		"127": {byte(PUBLISH << 4), 0xFF, 0x00},
+		"128": {byte(PUBLISH << 4), 0x80, 0x81, 0x00},
+		"1":   {byte(PUBLISH << 4), 0x81, 0x80, 0x80, 0x00},
+	}
+
+	for name, buf := range overlong {
+		require.EqualError(t, ValidateMinimalVBI(buf), CodeMalformedPacket.Error(), name)
+	}
+
+	require.EqualError(t, ValidateMinimalVBI([]byte{byte(PUBLISH << 4), 0x80}), ErrInsufficientDataSize.Error())
+	require.EqualError(t, ValidateMinimalVBI([]byte{byte(PUBLISH << 4), 0x80, 0x80, 0x80, 0x80, 0x01}), ErrInvalidLength.Error())
+	require.EqualError(t, ValidateMinimalVBI([]byte{byte(PUBLISH << 4)}), ErrInsufficientBufferSize.Error())
+}
+
+type recordingWriter struct {
+	writes [][]byte
+	err    error
+	limit  int
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	if r.limit > 0 && len(p) > r.limit {
+		p = p[:r.limit]
+	}
+
+	r.writes = append(r.writes, append([]byte(nil), p...))
+	return len(p), r.err
+}
+
+func TestMessageWriterAutoFlush(t *testing.T) {
+	w := &recordingWriter{}
+	mw := NewMessageWriter(w, 6)
+
+	ping, err := New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+
+	// PINGREQ is 2 bytes
+	require.NoError(t, mw.Queue(ping))
+	require.NoError(t, mw.Queue(ping))
+	require.Equal(t, 4, mw.Buffered())
+	require.Len(t, w.writes, 0)
+
+	require.NoError(t, mw.Queue(ping))
+	require.Equal(t, 0, mw.Buffered())
+	require.Len(t, w.writes, 1)
+	require.Equal(t, []byte{0xC0, 0, 0xC0, 0, 0xC0, 0}, w.writes[0])
+
+	require.NoError(t, mw.Queue(ping))
+	require.NoError(t, mw.Flush())
+	require.NoError(t, mw.Flush())
+	require.Len(t, w.writes, 2)
+
+	w.err = io.ErrShortWrite
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, mw.Queue(ping))
+	}
+	require.Equal(t, io.ErrShortWrite, mw.Queue(ping))
+}
+
+func TestMessageWriterFlushKeepsUnwritten(t *testing.T) {
+	w := &recordingWriter{limit: 3}
+	mw := NewMessageWriter(w, 0)
+
+	ping, err := New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+
+	disconnect, err := New(ProtocolV311, DISCONNECT)
+	require.NoError(t, err)
+
+	require.NoError(t, mw.Queue(ping))
+	require.NoError(t, mw.Queue(disconnect))
+
+	// short write without error is reported and remainder stays queued
+	require.Equal(t, io.ErrShortWrite, mw.Flush())
+	require.Equal(t, 1, mw.Buffered())
+
+	require.NoError(t, mw.Flush())
+	require.Equal(t, 0, mw.Buffered())
+	require.Equal(t, [][]byte{{0xC0, 0, 0xE0}, {0}}, w.writes)
+
+	// failed write keeps bytes it did not write
+	w.writes = nil
+	w.limit = 0
+	w.err = io.ErrClosedPipe
+
+	require.NoError(t, mw.Queue(ping))
+
+	w.limit = 1
+	require.Equal(t, io.ErrClosedPipe, mw.Flush())
+	require.Equal(t, 1, mw.Buffered())
+
+	w.err = nil
+	w.limit = 0
+	require.NoError(t, mw.Flush())
+	require.Equal(t, [][]byte{{0xC0}, {0}}, w.writes)
+}
+
+func TestReadMessage(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub := m.(*Subscribe)
+	sub.SetPacketID(3)
+	require.NoError(t, sub.AddTopic("a/b", SubscriptionOptions(QoS1)))
+
+	buf, err := Encode(sub)
+	require.NoError(t, err)
+
+	ping, err := Encode(newPingReqForTest(t))
+	require.NoError(t, err)
+
+	r := bytes.NewReader(append(append([]byte(nil), buf...), ping...))
+
+	msg, n, err := ReadMessage(ProtocolV311, r, len(buf))
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+	require.Equal(t, []string{"a/b"}, msg.(*Subscribe).Topics())
+
+	msg, _, err = ReadMessage(ProtocolV311, r, 0)
+	require.NoError(t, err)
+	require.Equal(t, PINGREQ, msg.Type())
+
+	_, _, err = ReadMessage(ProtocolV311, r, 0)
+	require.Equal(t, io.EOF, err)
+
+	// declared remaining length of 2MB is rejected before body is read
+	oversized := bytes.NewReader([]byte{byte(PUBLISH << 4), 0x80, 0x80, 0x80, 0x01, 0, 1})
+	_, _, err = ReadMessage(ProtocolV311, oversized, 1024)
+	require.EqualError(t, err, ErrPacketTooLarge.Error())
+	require.Equal(t, 2, oversized.Len())
+
+	_, _, err = ReadMessage(ProtocolV311, bytes.NewReader(buf[:len(buf)-2]), 0)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, _, err = ReadMessage(ProtocolV311, bytes.NewReader([]byte{byte(PUBLISH << 4), 0x80}), 0)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, _, err = ReadMessage(ProtocolV311, bytes.NewReader([]byte{byte(PUBLISH << 4), 0x80, 0x80, 0x80, 0x80, 0x01}), 0)
+	require.EqualError(t, err, ErrInvalidLength.Error())
+}
+
+func newPingReqForTest(t *testing.T) Provider {
+	m, err := New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+	return m
+}
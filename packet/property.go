@@ -2,6 +2,7 @@ package packet
 
 import (
 	"encoding/binary"
+	"sort"
 	"unicode/utf8"
 )
 
@@ -136,6 +137,7 @@ func (t *propertyToType) AsBinary() ([]byte, error) {
 type property struct {
 	properties map[PropertyID]interface{}
 	len        uint32
+	lenient    bool
 }
 
 // nolint: golint
@@ -220,6 +222,7 @@ var propertyAllowedMessageTypes = map[PropertyID]map[Type]bool{
 		CONNECT:    true,
 		CONNACK:    true,
 		PUBLISH:    true,
+		SUBSCRIBE:  true,
 		PUBACK:     true,
 		PUBREC:     true,
 		PUBREL:     true,
@@ -341,6 +344,9 @@ func (p *property) Set(t Type, id PropertyID, val interface{}) error {
 		return ErrPropertyPacketTypeMismatch
 	}
 
+	// replaced value must not be counted twice
+	p.del(id)
+
 	fn := propertyCalcLen[propertyTypeMap[id]]
 	l, _ := fn(id, val)
 	p.len += uint32(l)
@@ -349,6 +355,26 @@ func (p *property) Set(t Type, id PropertyID, val interface{}) error {
 	return nil
 }
 
+// reset removes all properties keeping allocated map
+func (p *property) reset() {
+	for id := range p.properties {
+		delete(p.properties, id)
+	}
+
+	p.len = 0
+	p.lenient = false
+}
+
+// del removes property if it is set
+func (p *property) del(id PropertyID) {
+	if val, ok := p.properties[id]; ok {
+		fn := propertyCalcLen[propertyTypeMap[id]]
+		l, _ := fn(id, val)
+		p.len -= uint32(l)
+		delete(p.properties, id)
+	}
+}
+
 // Get property value
 func (p *property) Get(id PropertyID) PropertyToType {
 	if val, ok := p.properties[id]; ok {
@@ -420,17 +446,23 @@ func (p *property) decode(t Type, from []byte) (int, error) {
 
 		id := PropertyID(idVal)
 
+		target := p
+
 		if !id.IsValidPacketType(t) {
-			return offset, CodeMalformedPacket
-		}
+			// in lenient mode property known by spec but not handled for this packet type
+			// is skipped using its wire type
+			if !p.lenient || !id.IsValid() {
+				return offset, CodeMalformedPacket
+			}
 
-		if _, ok := p.properties[id]; ok && !id.DupAllowed(t) {
+			target = &property{properties: make(map[PropertyID]interface{})}
+		} else if _, ok := p.properties[id]; ok && !id.DupAllowed(t) {
 			return offset, CodeProtocolError
 		}
 
 		if decodeFunc, ok := propertyDecodeType[propertyTypeMap[id]]; ok {
 			var decodeCount int
-			decodeCount, err = decodeFunc(p, id, slice[count:])
+			decodeCount, err = decodeFunc(target, id, slice[count:])
 			count += decodeCount
 			offset += count
 			if err != nil {
@@ -440,7 +472,9 @@ func (p *property) decode(t Type, from []byte) (int, error) {
 			return offset, CodeProtocolError
 		}
 
-		p.len += uint32(count)
+		if target == p {
+			p.len += uint32(count)
+		}
 		pLen -= uint32(count)
 	}
 
@@ -462,9 +496,18 @@ func (p *property) encode(to []byte) (int, error) {
 	// Encode variable length header
 	total := binary.PutUvarint(to, uint64(p.len))
 
-	for k, v := range p.properties {
+	// encode properties in ascending order of ids so same message always produces same bytes
+	ids := make([]int, 0, len(p.properties))
+	for k := range p.properties {
+		ids = append(ids, int(k))
+	}
+
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		k := PropertyID(id)
 		fn := propertyEncodeType[propertyTypeMap[k]]
-		offset, err = fn(k, v, to[total:])
+		offset, err = fn(k, p.properties[k], to[total:])
 		total += offset
 
 		if err != nil {
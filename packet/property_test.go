@@ -2,6 +2,8 @@ package packet
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestPropertyDecodeValid(t *testing.T) {
@@ -11,3 +13,65 @@ func TestPropertyDecodeValid(t *testing.T) {
 func TestPropertyEncodeValid(t *testing.T) {
 
 }
+
+func TestPropertyEncodeOrder(t *testing.T) {
+	expected := []byte{
+		byte(CONNACK << 4),
+		13,
+		0, // session present
+		0, // return code
+		10,
+		byte(PropertyServerKeepAlive), 0, 10,
+		byte(PropertyReceiveMaximum), 0, 20,
+		byte(PropertyMaximumQoS), 1,
+		byte(PropertyRetainAvailable), 0,
+	}
+
+	for i := 0; i < 20; i++ {
+		m, err := New(ProtocolV50, CONNACK)
+		require.NoError(t, err)
+
+		require.NoError(t, m.PropertySet(PropertyRetainAvailable, byte(0)))
+		require.NoError(t, m.PropertySet(PropertyMaximumQoS, byte(1)))
+		require.NoError(t, m.PropertySet(PropertyReceiveMaximum, uint16(20)))
+		require.NoError(t, m.PropertySet(PropertyServerKeepAlive, uint16(10)))
+
+		buf, err := Encode(m)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf)
+	}
+}
+
+func TestPropertyDecodeLenient(t *testing.T) {
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		18,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		9, // property length
+		byte(PropertyReasonString),
+		0, 6, 'r', 'e', 'a', 's', 'o', 'n',
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', '/', 'b',
+		1, // subscription options
+	}
+
+	_, _, err := Decode(ProtocolV50, msgBytes)
+	require.EqualError(t, err, CodeMalformedPacket.Error())
+
+	m, n, err := DecodeLenient(ProtocolV50, msgBytes)
+	require.NoError(t, err)
+	require.Equal(t, len(msgBytes), n)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	require.Equal(t, []string{"a/b"}, msg.topics)
+	require.Nil(t, msg.PropertyGet(PropertyReasonString))
+	require.Equal(t, uint32(1), msg.properties.FullLen())
+
+	// unknown property id cannot be skipped
+	msgBytes[5] = 0x7F
+	_, _, err = DecodeLenient(ProtocolV50, msgBytes)
+	require.EqualError(t, err, CodeMalformedPacket.Error())
+}
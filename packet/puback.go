@@ -55,6 +55,15 @@ func (msg *Ack) SetReason(c ReasonCode) {
 	msg.reasonCode = c
 }
 
+// MinProtocolVersion returns ProtocolV50 if reason code differs from success or properties are set
+func (msg *Ack) MinProtocolVersion() ProtocolVersion {
+	if msg.reasonCode != CodeSuccess {
+		return ProtocolV50
+	}
+
+	return msg.header.MinProtocolVersion()
+}
+
 // Reason return acknowledgment reason
 func (msg *Ack) Reason() ReasonCode {
 	return msg.reasonCode
@@ -238,6 +238,33 @@ func (msg *Publish) SetPayload(v []byte) {
 	msg.payload = v
 }
 
+// TopicAlias returns v5 Topic Alias and whether it is present
+func (msg *Publish) TopicAlias() (uint16, bool) {
+	if prop := msg.PropertyGet(PropertyTopicAlias); prop != nil {
+		if v, err := prop.AsShort(); err == nil {
+			return v, true
+		}
+	}
+
+	return 0, false
+}
+
+// ValidateTopicAlias check Topic Alias if present is not 0 and does not exceed
+// Topic Alias Maximum negotiated for the connection
+func (msg *Publish) ValidateTopicAlias(max uint16) error {
+	alias, ok := msg.TopicAlias()
+	if !ok {
+		return nil
+	}
+
+	// v5.0 [MQTT-3.3.2-8] [MQTT-3.3.2-9] [MQTT-3.3.2-10]
+	if alias == 0 || alias > max {
+		return CodeInvalidTopicAlias
+	}
+
+	return nil
+}
+
 // SetPacketID sets the ID of the packet.
 func (msg *Publish) SetPacketID(v IDType) {
 	msg.setPacketID(v)
@@ -305,6 +332,11 @@ func (msg *Publish) decodeMessage(from []byte) (int, error) {
 			return offset, err
 		}
 
+		// v5.0 [MQTT-3.3.2.3.4] topic alias value of 0 is not permitted
+		if alias, ok := msg.TopicAlias(); ok && alias == 0 {
+			return offset, CodeInvalidTopicAlias
+		}
+
 		// if packet does not have topic set there must be topic alias set in properties
 		if len(msg.topic) == 0 {
 			reject := CodeProtocolError
@@ -63,3 +63,43 @@ func TestPublishExpire(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, val <= 3 && val > 0)
 }
+
+func TestPublishTopicAlias(t *testing.T) {
+	raw := func(alias byte) []byte {
+		return []byte{
+			byte(PUBLISH << 4),
+			11,   // remaining length
+			0, 5, // topic length
+			't', 'o', 'p', 'i', 'c',
+			3, // properties length
+			byte(PropertyTopicAlias),
+			0, alias,
+		}
+	}
+
+	m, _, err := Decode(ProtocolV50, raw(5))
+	require.NoError(t, err)
+
+	pkt, ok := m.(*Publish)
+	require.True(t, ok)
+
+	alias, ok := pkt.TopicAlias()
+	require.True(t, ok)
+	require.Equal(t, uint16(5), alias)
+	require.NoError(t, pkt.ValidateTopicAlias(10))
+	require.NoError(t, pkt.ValidateTopicAlias(5))
+	require.EqualError(t, pkt.ValidateTopicAlias(4), CodeInvalidTopicAlias.Error())
+
+	_, _, err = Decode(ProtocolV50, raw(0))
+	require.EqualError(t, err, CodeInvalidTopicAlias.Error())
+
+	m, err = New(ProtocolV50, PUBLISH)
+	require.NoError(t, err)
+
+	_, ok = m.(*Publish).TopicAlias()
+	require.False(t, ok)
+	require.NoError(t, m.(*Publish).ValidateTopicAlias(0))
+
+	require.NoError(t, m.PropertySet(PropertyTopicAlias, uint16(0)))
+	require.EqualError(t, m.(*Publish).ValidateTopicAlias(10), CodeInvalidTopicAlias.Error())
+}
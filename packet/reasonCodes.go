@@ -1,5 +1,9 @@
 package packet
 
+import (
+	"fmt"
+)
+
 // ReasonCode contains return codes across all MQTT specs
 type ReasonCode byte
 
@@ -172,8 +176,8 @@ var packetTypeCodeMap = map[Type]map[ReasonCode]struct {
 		CodeNotSupportedQoS:                   {iss: CodeIssuerServer, desc: "The Client specified a QoS greater then the QoS specified in a Maximum QoS in the CONNACK"},
 		CodeUseAnotherServer:                  {iss: CodeIssuerServer, desc: "The Client should temporarily change its Server"},
 		CodeServerMoved:                       {iss: CodeIssuerServer, desc: "The Server is moved and the client should permanently change its server location"},
-		CodeSharedSubscriptionNotSupported:    {iss: 0, desc: ""},
-		CodeConnectionRateExceeded:            {iss: 0, desc: ""},
+		CodeSharedSubscriptionNotSupported:    {iss: 0},
+		CodeConnectionRateExceeded:            {iss: 0},
 		CodeMaximumConnectTime:                {iss: CodeIssuerServer, desc: "The maximum connection time authorized for this connection has been exceeded"},
 		CodeSubscriptionIDNotSupported:        {iss: CodeIssuerServer, desc: "The Server does not support subscription identifiers; the subscription is not accepted"},
 		CodeWildcardSubscriptionsNotSupported: {iss: CodeIssuerServer, desc: "The Server does not support Wildcard subscription; the subscription is not accepted"},
@@ -194,53 +198,60 @@ var packetTypeCodeMap = map[Type]map[ReasonCode]struct {
 	},
 }
 
-var codeDescMap = map[ReasonCode]string{
-	CodeSuccess:                            "Operation success",
-	CodeRefusedUnacceptableProtocolVersion: "The Server does not support the level of the MQTT protocol requested by the Client",
-	CodeRefusedIdentifierRejected:          "The Client identifier is not allowed",
-	CodeRefusedServerUnavailable:           "Server refused connection",
-	CodeRefusedBadUsernameOrPassword:       "The data in the user name or password is malformed",
-	CodeRefusedNotAuthorized:               "The Client is not authorized to connect",
+// codeText short name and description of reason code
+// name is empty for codes without name in SUBACK, UNSUBACK or MQTT 5.0, e.g. V3.1.1 CONNACK refusals
+type codeText struct {
+	name string
+	desc string
+}
+
+var codeTextMap = map[ReasonCode]codeText{
+	CodeSuccess:                            {name: "Success (Granted QoS 0)", desc: "Operation success"},
+	CodeRefusedUnacceptableProtocolVersion: {name: "Granted QoS 1", desc: "The Server does not support the level of the MQTT protocol requested by the Client"},
+	CodeRefusedIdentifierRejected:          {name: "Granted QoS 2", desc: "The Client identifier is not allowed"},
+	CodeRefusedServerUnavailable:           {desc: "Server refused connection"},
+	CodeRefusedBadUsernameOrPassword:       {desc: "The data in the user name or password is malformed"},
+	CodeRefusedNotAuthorized:               {desc: "The Client is not authorized to connect"},
 	//CodeRefusedBadUsernameOrPassword:       "",
-	CodeNoMatchingSubscribers:             "The message is accepted but there are no subscribers",
-	CodeNoSubscriptionExisted:             "No matching subscription existed",
-	CodeContinueAuthentication:            "Continue the authentication with another step",
-	CodeReAuthenticate:                    "Initiate a re-authentication",
-	CodeUnspecifiedError:                  "Return code not specified by application",
-	CodeMalformedPacket:                   "Data within the Packet was not consistent with this specification",
-	CodeProtocolError:                     "",
-	CodeImplementationSpecificError:       "",
-	CodeUnsupportedProtocol:               "",
-	CodeInvalidClientID:                   "",
-	CodeBadUserOrPassword:                 "",
-	CodeNotAuthorized:                     "",
-	CodeServerUnavailable:                 "",
-	CodeServerBusy:                        "",
-	CodeBanned:                            "",
-	CodeServerShuttingDown:                "",
-	CodeBadAuthMethod:                     "",
-	CodeKeepAliveTimeout:                  "",
-	CodeSessionTakenOver:                  "",
-	CodeInvalidTopicFilter:                "",
-	CodeInvalidTopicName:                  "",
-	CodePacketIDInUse:                     "",
-	CodePacketIDNotFound:                  "",
-	CodeReceiveMaximumExceeded:            "",
-	CodeInvalidTopicAlias:                 "Invalid topic alias",
-	CodePacketTooLarge:                    "",
-	CodeMessageRateTooHigh:                "",
-	CodeQuotaExceeded:                     "",
-	CodeAdministrativeAction:              "",
-	CodeInvalidPayloadFormat:              "",
-	CodeRetainNotSupported:                "",
-	CodeNotSupportedQoS:                   "",
-	CodeUseAnotherServer:                  "",
-	CodeServerMoved:                       "",
-	CodeSharedSubscriptionNotSupported:    "",
-	CodeConnectionRateExceeded:            "",
-	CodeMaximumConnectTime:                "",
-	CodeSubscriptionIDNotSupported:        "",
-	CodeWildcardSubscriptionsNotSupported: "",
+	CodeNoMatchingSubscribers:             {name: "No matching subscribers", desc: "The message is accepted but there are no subscribers"},
+	CodeNoSubscriptionExisted:             {name: "No subscription existed", desc: "No matching subscription existed"},
+	CodeContinueAuthentication:            {name: "Continue authentication", desc: "Continue the authentication with another step"},
+	CodeReAuthenticate:                    {name: "Re-authenticate", desc: "Initiate a re-authentication"},
+	CodeUnspecifiedError:                  {name: "Unspecified error", desc: "Return code not specified by application"},
+	CodeMalformedPacket:                   {name: "Malformed Packet", desc: "Data within the Packet was not consistent with this specification"},
+	CodeProtocolError:                     {name: "Protocol Error"},
+	CodeImplementationSpecificError:       {name: "Implementation specific error"},
+	CodeUnsupportedProtocol:               {name: "Unsupported Protocol Version"},
+	CodeInvalidClientID:                   {name: "Client Identifier not valid"},
+	CodeBadUserOrPassword:                 {name: "Bad User Name or Password"},
+	CodeNotAuthorized:                     {name: "Not authorized"},
+	CodeServerUnavailable:                 {name: "Server unavailable"},
+	CodeServerBusy:                        {name: "Server busy"},
+	CodeBanned:                            {name: "Banned"},
+	CodeServerShuttingDown:                {name: "Server shutting down"},
+	CodeBadAuthMethod:                     {name: "Bad authentication method"},
+	CodeKeepAliveTimeout:                  {name: "Keep Alive timeout"},
+	CodeSessionTakenOver:                  {name: "Session taken over"},
+	CodeInvalidTopicFilter:                {name: "Topic Filter invalid"},
+	CodeInvalidTopicName:                  {name: "Topic Name invalid"},
+	CodePacketIDInUse:                     {name: "Packet Identifier in use"},
+	CodePacketIDNotFound:                  {name: "Packet Identifier not found"},
+	CodeReceiveMaximumExceeded:            {name: "Receive Maximum exceeded"},
+	CodeInvalidTopicAlias:                 {name: "Topic Alias invalid", desc: "Invalid topic alias"},
+	CodePacketTooLarge:                    {name: "Packet too large"},
+	CodeMessageRateTooHigh:                {name: "Message rate too high"},
+	CodeQuotaExceeded:                     {name: "Quota exceeded"},
+	CodeAdministrativeAction:              {name: "Administrative action"},
+	CodeInvalidPayloadFormat:              {name: "Payload format invalid"},
+	CodeRetainNotSupported:                {name: "Retain not supported"},
+	CodeNotSupportedQoS:                   {name: "QoS not supported"},
+	CodeUseAnotherServer:                  {name: "Use another server"},
+	CodeServerMoved:                       {name: "Server moved"},
+	CodeSharedSubscriptionNotSupported:    {name: "Shared Subscriptions not supported"},
+	CodeConnectionRateExceeded:            {name: "Connection rate exceeded"},
+	CodeMaximumConnectTime:                {name: "Maximum connect time"},
+	CodeSubscriptionIDNotSupported:        {name: "Subscription Identifiers not supported"},
+	CodeWildcardSubscriptionsNotSupported: {name: "Wildcard Subscriptions not supported"},
 }
 
 // PacketTypeDir check direction of packet type
@@ -264,7 +275,7 @@ func (c ReasonCode) Value() byte {
 
 // IsValid check either reason code is valid across all MQTT specs
 func (c ReasonCode) IsValid() bool {
-	if _, ok := codeDescMap[c]; ok {
+	if _, ok := codeTextMap[c]; ok {
 		return true
 	}
 	return false
@@ -301,9 +312,14 @@ func (c ReasonCode) IsValidForType(p Type) bool {
 }
 
 // Error returns the description of the ReturnCode
+// codes without description fall back to their name
 func (c ReasonCode) Error() string {
-	if s, ok := codeDescMap[c]; ok {
-		return s
+	if s, ok := codeTextMap[c]; ok {
+		if len(s.desc) == 0 {
+			return s.name
+		}
+
+		return s.desc
 	}
 
 	return "Unknown error"
@@ -313,3 +329,15 @@ func (c ReasonCode) Error() string {
 func (c ReasonCode) Desc() string {
 	return c.Error()
 }
+
+// ReasonCodeString returns short human readable name of SUBACK return code or
+// MQTT 5.0 reason code suitable for logs, e.g. "Granted QoS 1" or "Not authorized".
+// Code 0x00 means either success or granted QoS 0 depending on the packet.
+// Unknown codes are formatted as "Unknown (0xNN)"
+func ReasonCodeString(code byte) string {
+	if s, ok := codeTextMap[ReasonCode(code)]; ok && len(s.name) != 0 {
+		return s.name
+	}
+
+	return fmt.Sprintf("Unknown (0x%02X)", code)
+}
@@ -0,0 +1,42 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReasonCodeString(t *testing.T) {
+	tests := []struct {
+		code byte
+		name string
+	}{
+		{0x00, "Success (Granted QoS 0)"},
+		{0x01, "Granted QoS 1"},
+		{0x02, "Granted QoS 2"},
+		{QosFailure, "Unspecified error"},
+		{0x11, "No subscription existed"},
+		{0x87, "Not authorized"},
+		{0x8F, "Topic Filter invalid"},
+		{0xA2, "Wildcard Subscriptions not supported"},
+		{0x03, "Unknown (0x03)"},
+		{0xFF, "Unknown (0xFF)"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.name, ReasonCodeString(tt.code))
+	}
+
+	// every known reason code has name
+	for c, s := range codeTextMap {
+		if c.IsValidV5() {
+			require.NotEmpty(t, s.name, "code 0x%02X", byte(c))
+			require.NotEmpty(t, c.Error(), "code 0x%02X", byte(c))
+		}
+	}
+
+	// name and description come from same table
+	require.Equal(t, "Protocol Error", CodeProtocolError.Error())
+	require.Equal(t, "Invalid topic alias", CodeInvalidTopicAlias.Error())
+	require.Equal(t, "Topic Alias invalid", ReasonCodeString(byte(CodeInvalidTopicAlias)))
+}
@@ -2,8 +2,180 @@ package packet
 
 import (
 	"encoding/binary"
+	"io"
 )
 
+// CountingWriter wraps io.Writer and counts total amount of bytes written through it
+type CountingWriter struct {
+	w     io.Writer
+	total int64
+}
+
+// NewCountingWriter allocate new CountingWriter on top of w
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+// Write implements io.Writer
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.total += int64(n)
+	return n, err
+}
+
+// BytesWritten returns total amount of bytes written so far
+func (c *CountingWriter) BytesWritten() int64 {
+	return c.total
+}
+
+// MessageWriter batches encoded messages and writes them into underlying writer at once
+type MessageWriter struct {
+	w        io.Writer
+	buf      []byte
+	maxBytes int
+}
+
+// NewMessageWriter allocate MessageWriter on top of w
+// Queued messages are flushed automatically once amount of buffered bytes reaches maxBytes.
+// maxBytes of 0 disables auto flush
+func NewMessageWriter(w io.Writer, maxBytes int) *MessageWriter {
+	return &MessageWriter{
+		w:        w,
+		maxBytes: maxBytes,
+	}
+}
+
+// Queue encode message into batch buffer
+// If threshold is reached batch is flushed and flush error is returned
+func (mw *MessageWriter) Queue(msg Provider) error {
+	sz, err := msg.Size()
+	if err != nil {
+		return err
+	}
+
+	offset := len(mw.buf)
+
+	if cap(mw.buf)-offset < sz {
+		buf := make([]byte, offset, 2*cap(mw.buf)+sz)
+		copy(buf, mw.buf)
+		mw.buf = buf
+	}
+
+	mw.buf = mw.buf[:offset+sz]
+
+	if _, err = msg.Encode(mw.buf[offset:]); err != nil {
+		mw.buf = mw.buf[:offset]
+		return err
+	}
+
+	if mw.maxBytes > 0 && len(mw.buf) >= mw.maxBytes {
+		return mw.Flush()
+	}
+
+	return nil
+}
+
+// Buffered returns amount of bytes queued and not flushed yet
+func (mw *MessageWriter) Buffered() int {
+	return len(mw.buf)
+}
+
+// Flush writes all queued messages into underlying writer
+// If write fails or is short unwritten bytes stay queued for next flush
+func (mw *MessageWriter) Flush() error {
+	if len(mw.buf) == 0 {
+		return nil
+	}
+
+	n, err := mw.w.Write(mw.buf)
+	if n < len(mw.buf) && err == nil {
+		err = io.ErrShortWrite
+	}
+
+	if err != nil {
+		if n > 0 {
+			copy(mw.buf, mw.buf[n:])
+			mw.buf = mw.buf[:len(mw.buf)-n]
+		}
+
+		return err
+	}
+
+	mw.buf = mw.buf[:0]
+
+	return nil
+}
+
+// ReadMessage reads single packet from r and decodes it
+// Size of the packet declared by fixed header is checked against maxSize before body is read,
+// thus oversized packet returns ErrPacketTooLarge without allocating memory for it.
+// maxSize of 0 means no limit beyond maximum remaining length
+func ReadMessage(v ProtocolVersion, r io.Reader, maxSize int) (Provider, int, error) {
+	// fixed header is packet type and up to 4 bytes of remaining length
+	var hdr [5]byte
+
+	if _, err := io.ReadFull(r, hdr[:1]); err != nil {
+		return nil, 0, err
+	}
+
+	total := 1
+
+	var remLen uint32
+
+	for {
+		if total == len(hdr) {
+			return nil, total, ErrInvalidLength
+		}
+
+		if _, err := io.ReadFull(r, hdr[total:total+1]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, total, err
+		}
+
+		total++
+
+		var n int
+		if remLen, n = uvarint(hdr[1:total]); n > 0 {
+			break
+		} else if n < 0 {
+			return nil, total, ErrInvalidLength
+		}
+	}
+
+	if remLen > uint32(maxRemainingLength) {
+		return nil, total, ErrInvalidLength
+	}
+
+	if maxSize > 0 && total+int(remLen) > maxSize {
+		return nil, total, ErrPacketTooLarge
+	}
+
+	buf := make([]byte, total+int(remLen))
+	copy(buf, hdr[:total])
+
+	if n, err := io.ReadFull(r, buf[total:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, total + n, err
+	}
+
+	return Decode(v, buf)
+}
+
+// WriteTo encode message and write it into w
+// If w is CountingWriter its BytesWritten after call is offset where next message starts
+func WriteTo(msg Provider, w io.Writer) (int, error) {
+	buf, err := Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.Write(buf)
+}
+
 // WriteToBuffer encode and send message into ring buffer
 //func WriteToBuffer(msg Provider, to *goring.Buffer) (int, error) {
 //	expectedSize, err := msg.Size()
@@ -57,6 +57,145 @@ func (msg *SubAck) AddReturnCode(ret ReasonCode) error {
 	return msg.AddReturnCodes([]ReasonCode{ret})
 }
 
+// ValidateSubAckResponse checks ack is valid response to sub, i.e. packet IDs are the same
+// and there is return code for each of subscriptions
+func ValidateSubAckResponse(sub *Subscribe, ack *SubAck) error {
+	if sub == nil || ack == nil {
+		return ErrInvalidArgs
+	}
+
+	frozen := sub.Freeze()
+
+	subID, ok := frozen.PacketID()
+	if !ok {
+		return ErrNotSet
+	}
+
+	ackID, err := ack.ID()
+	if err != nil {
+		return err
+	}
+
+	// [MQTT-3.8.4-2]
+	if subID != ackID {
+		return ErrPackedIDNotMatched
+	}
+
+	// [MQTT-3.8.4-5]
+	if len(frozen.topics) != len(ack.returnCodes) {
+		return ErrReturnCodesCountMismatch
+	}
+
+	return nil
+}
+
+// BuildSubAckAllGranted build SUBACK with packet ID of the subscribe granting each subscription requested QoS
+func (msg *Subscribe) BuildSubAckAllGranted() *SubAck {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	m, _ := New(msg.version, SUBACK)
+	ack, _ := m.(*SubAck)
+
+	ack.packetID = append([]byte(nil), msg.packetID...)
+	ack.returnCodes = make([]ReasonCode, len(msg.ops))
+
+	for i, ops := range msg.ops {
+		ack.returnCodes[i] = ReasonCode(ops.QoS())
+	}
+
+	return ack
+}
+
+// GrantAtomic build SUBACK with return codes given by grant for each subscription
+// All codes are collected before SUBACK is allocated, thus if grant fails or returns
+// code not valid for SUBACK no SUBACK is built and error is returned
+func (msg *Subscribe) GrantAtomic(grant func(i int, topic string, ops SubscriptionOptions) (ReasonCode, error)) (*SubAck, error) {
+	sub := msg.Freeze()
+	topics := sub.Topics()
+	ops := sub.Options()
+	version := msg.Version()
+
+	codes := make([]ReasonCode, len(topics))
+
+	for i, t := range topics {
+		code, err := grant(i, t, ops[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if version == ProtocolV50 {
+			if !code.IsValidForType(SUBACK) {
+				return nil, ErrInvalidReturnCode
+			}
+		} else if !QosType(code).IsValidFull() {
+			return nil, ErrInvalidReturnCode
+		}
+
+		codes[i] = code
+	}
+
+	m, _ := New(version, SUBACK)
+	ack, _ := m.(*SubAck)
+
+	if id, ok := sub.PacketID(); ok {
+		ack.SetPacketID(id)
+	}
+
+	ack.returnCodes = codes
+
+	return ack, nil
+}
+
+// ServerCaps server capabilities subscriptions are granted against
+type ServerCaps struct {
+	// MaximumQoS maximum QoS granted to any subscription
+	MaximumQoS QosType
+
+	// WildcardSubscriptionAvailable whether subscriptions with wildcards are allowed
+	WildcardSubscriptionAvailable bool
+}
+
+// GrantCapped build SUBACK granting each subscription QoS capped at caps.MaximumQoS
+// Subscriptions authorize returns false for are denied with CodeNotAuthorized, wildcard
+// subscriptions are denied with CodeWildcardSubscriptionsNotSupported if caps do not allow them.
+// For MQTT 3.1 and 3.1.1 both denials are reported as QosFailure
+func (msg *Subscribe) GrantCapped(caps ServerCaps, authorize func(topic string) bool) *SubAck {
+	version := msg.Version()
+
+	ack, _ := msg.GrantAtomic(func(i int, topic string, ops SubscriptionOptions) (ReasonCode, error) {
+		filter := topic
+		if _, f, ok := ParseSharedSubscription(topic); ok {
+			filter = f
+		}
+
+		if !caps.WildcardSubscriptionAvailable && !ValidTopic(filter) {
+			return denyCode(version, CodeWildcardSubscriptionsNotSupported), nil
+		}
+
+		if authorize != nil && !authorize(topic) {
+			return denyCode(version, CodeNotAuthorized), nil
+		}
+
+		qos := ops.QoS()
+		if qos > caps.MaximumQoS {
+			qos = caps.MaximumQoS
+		}
+
+		return ReasonCode(qos), nil
+	})
+
+	return ack
+}
+
+func denyCode(v ProtocolVersion, code ReasonCode) ReasonCode {
+	if v == ProtocolV50 {
+		return code
+	}
+
+	return ReasonCode(QosFailure)
+}
+
 // SetPacketID sets the ID of the packet.
 func (msg *SubAck) SetPacketID(v IDType) {
 	msg.setPacketID(v)
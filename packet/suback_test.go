@@ -15,6 +15,8 @@
 package packet
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -145,3 +147,150 @@ func TestSubAckDecodeEncodeEquiv(t *testing.T) {
 	require.NoError(t, err, "Error decoding message")
 	require.Equal(t, len(msgBytes), n3, "Error decoding message")
 }
+
+func TestSubAckValidateResponse(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	sub.SetPacketID(7)
+	require.NoError(t, sub.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, sub.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+
+	m, err = New(ProtocolV311, SUBACK)
+	require.NoError(t, err)
+
+	ack, ok := m.(*SubAck)
+	require.True(t, ok, "Couldn't cast message type")
+	ack.SetPacketID(7)
+	require.NoError(t, ack.AddReturnCodes([]ReasonCode{ReasonCode(QoS1), ReasonCode(QoS0)}))
+
+	require.NoError(t, ValidateSubAckResponse(sub, ack))
+
+	ack.SetPacketID(8)
+	require.EqualError(t, ValidateSubAckResponse(sub, ack), ErrPackedIDNotMatched.Error())
+
+	ack.SetPacketID(7)
+	require.NoError(t, ack.AddReturnCode(ReasonCode(QoS0)))
+	require.EqualError(t, ValidateSubAckResponse(sub, ack), ErrReturnCodesCountMismatch.Error())
+
+	require.EqualError(t, ValidateSubAckResponse(nil, ack), ErrInvalidArgs.Error())
+}
+
+func TestBuildSubAckAllGranted(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		sub, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		sub.SetPacketID(42)
+		require.NoError(t, sub.AddTopic("a/b", SubscriptionOptions(QoS2)))
+		require.NoError(t, sub.AddTopic("c/#", SubscriptionOptions(QoS0)))
+		require.NoError(t, sub.AddTopic("d/+", SubscriptionOptions(QoS1)))
+
+		ack := sub.BuildSubAckAllGranted()
+		require.Equal(t, []ReasonCode{ReasonCode(QoS2), ReasonCode(QoS0), ReasonCode(QoS1)}, ack.ReturnCodes())
+		require.Equal(t, v, ack.Version())
+		require.NoError(t, ValidateSubAckResponse(sub, ack))
+
+		buf := make([]byte, sub.ExpectedSubAckSize(v))
+		_, err = ack.Encode(buf)
+		require.NoError(t, err)
+	}
+}
+
+func TestSubscribeGrantAtomic(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	sub.SetPacketID(9)
+	require.NoError(t, sub.AddTopics([]string{"a", "b", "c", "d"}, []SubscriptionOptions{2, 2, 1, 0}))
+
+	errDenied := errors.New("denied")
+	calls := 0
+
+	ack, err := sub.GrantAtomic(func(i int, topic string, ops SubscriptionOptions) (ReasonCode, error) {
+		calls++
+		if i == 2 {
+			return 0, errDenied
+		}
+		return ReasonCode(ops.QoS()), nil
+	})
+	require.Equal(t, errDenied, err)
+	require.Nil(t, ack)
+	require.Equal(t, 3, calls)
+
+	ack, err = sub.GrantAtomic(func(i int, topic string, ops SubscriptionOptions) (ReasonCode, error) {
+		return ReasonCode(0x05), nil
+	})
+	require.EqualError(t, err, ErrInvalidReturnCode.Error())
+	require.Nil(t, ack)
+
+	ack, err = sub.GrantAtomic(func(i int, topic string, ops SubscriptionOptions) (ReasonCode, error) {
+		if topic == "b" {
+			return CodeUnspecifiedError, nil
+		}
+
+		// downgrade to QoS1 at most
+		if ops.QoS() > QoS1 {
+			return ReasonCode(QoS1), nil
+		}
+		return ReasonCode(ops.QoS()), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []ReasonCode{ReasonCode(QoS1), CodeUnspecifiedError, ReasonCode(QoS1), ReasonCode(QoS0)}, ack.ReturnCodes())
+	require.NoError(t, ValidateSubAckResponse(sub, ack))
+}
+
+func TestSubscribeGrantCapped(t *testing.T) {
+	authorize := func(topic string) bool {
+		return !strings.HasPrefix(topic, "private/")
+	}
+
+	topics := []string{"a/b", "a/+", "private/x", "c", "$share/g/d/#", "private/#"}
+	ops := []SubscriptionOptions{2, 1, 1, 0, 2, 1}
+
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		sub, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		sub.SetPacketID(4)
+		require.NoError(t, sub.AddTopics(topics, ops))
+
+		deniedWildcard := CodeWildcardSubscriptionsNotSupported
+		deniedAuth := CodeNotAuthorized
+		if v != ProtocolV50 {
+			deniedWildcard = ReasonCode(QosFailure)
+			deniedAuth = ReasonCode(QosFailure)
+		}
+
+		ack := sub.GrantCapped(ServerCaps{MaximumQoS: QoS1}, authorize)
+		require.NotNil(t, ack)
+		require.Equal(t,
+			[]ReasonCode{ReasonCode(QoS1), deniedWildcard, deniedAuth, ReasonCode(QoS0), deniedWildcard, deniedWildcard},
+			ack.ReturnCodes())
+		require.NoError(t, ValidateSubAckResponse(sub, ack))
+
+		ack = sub.GrantCapped(ServerCaps{MaximumQoS: QoS2, WildcardSubscriptionAvailable: true}, authorize)
+		require.NotNil(t, ack)
+		require.Equal(t,
+			[]ReasonCode{ReasonCode(QoS2), ReasonCode(QoS1), deniedAuth, ReasonCode(QoS0), ReasonCode(QoS2), deniedAuth},
+			ack.ReturnCodes())
+		require.NoError(t, ValidateSubAckResponse(sub, ack))
+
+		size, err := ack.Size()
+		require.NoError(t, err)
+
+		_, err = ack.Encode(make([]byte, size))
+		require.NoError(t, err)
+	}
+}
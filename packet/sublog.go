@@ -0,0 +1,103 @@
+package packet
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// log record consists of 4 bytes big-endian length of protocol version and packet,
+// 1 byte protocol version, encoded packet and 4 bytes big-endian CRC32 (IEEE)
+// of protocol version and packet
+const (
+	logRecordLenSize = 4
+	logRecordCRCSize = 4
+)
+
+// logRecordMaxLen protocol version followed by largest SUBSCRIBE Decode accepts
+func logRecordMaxLen() int {
+	max := maxRemainingLength
+	if limit, ok := remainingLengthLimit(SUBSCRIBE); ok {
+		max = limit
+	}
+
+	return 1 + 1 + uvarintCalc(uint32(max)) + int(max)
+}
+
+// AppendToLog writes SUBSCRIBE as checksum protected record into w
+func AppendToLog(w io.Writer, msg *Subscribe) error {
+	// single call so content can't change between sizing and encoding
+	pkt, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// record ReplayLog would reject is never written
+	if 1+len(pkt) > logRecordMaxLen() {
+		return ErrInvalidLength
+	}
+
+	buf := make([]byte, logRecordLenSize+1+len(pkt)+logRecordCRCSize)
+
+	binary.BigEndian.PutUint32(buf, uint32(1+len(pkt)))
+	buf[logRecordLenSize] = byte(msg.Version())
+	copy(buf[logRecordLenSize+1:], pkt)
+
+	body := buf[logRecordLenSize : logRecordLenSize+1+len(pkt)]
+	binary.BigEndian.PutUint32(buf[logRecordLenSize+1+len(pkt):], crc32.ChecksumIEEE(body))
+
+	_, err = w.Write(buf)
+
+	return err
+}
+
+// ReplayLog reads all records written by AppendToLog
+// ErrChecksumMismatch is returned if any of records is corrupted
+func ReplayLog(r io.Reader) ([]*Subscribe, error) {
+	var res []*Subscribe
+
+	hdr := make([]byte, logRecordLenSize)
+	maxLen := logRecordMaxLen()
+
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return res, nil
+			}
+
+			return res, err
+		}
+
+		// length is not covered by CRC thus must be checked before allocation
+		recLen := int(binary.BigEndian.Uint32(hdr))
+		if recLen < 1 || recLen > maxLen {
+			return res, ErrInvalidLength
+		}
+
+		body := make([]byte, recLen+logRecordCRCSize)
+		if _, err := io.ReadFull(r, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return res, err
+		}
+
+		crcOffset := len(body) - logRecordCRCSize
+		if crc32.ChecksumIEEE(body[:crcOffset]) != binary.BigEndian.Uint32(body[crcOffset:]) {
+			return res, ErrChecksumMismatch
+		}
+
+		m, _, err := Decode(ProtocolVersion(body[0]), body[1:crcOffset])
+		if err != nil {
+			return res, err
+		}
+
+		msg, ok := m.(*Subscribe)
+		if !ok {
+			return res, ErrInvalidMessageType
+		}
+
+		res = append(res, msg)
+	}
+}
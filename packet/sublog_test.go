@@ -0,0 +1,172 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newLogSubscribe(t *testing.T, v ProtocolVersion, id IDType, topic string) *Subscribe {
+	m, err := New(v, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	msg.SetPacketID(id)
+	require.NoError(t, msg.AddTopic(topic, SubscriptionOptions(QoS1)))
+
+	return msg
+}
+
+func TestSubscriptionLogReplay(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 1, "sport/tennis")))
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV50, 2, "weather/#")))
+
+	msgs, err := ReplayLog(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(msgs))
+	require.Equal(t, []string{"sport/tennis"}, msgs[0].Topics())
+	require.Equal(t, ProtocolV311, msgs[0].Version())
+	require.Equal(t, []string{"weather/#"}, msgs[1].Topics())
+	require.Equal(t, ProtocolV50, msgs[1].Version())
+}
+
+func TestSubscriptionLogCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 1, "sport/tennis")))
+	first := buf.Len()
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 2, "weather/#")))
+
+	data := buf.Bytes()
+
+	// flip a topic byte in second record
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	corrupted[first+12] ^= 0xFF
+
+	msgs, err := ReplayLog(bytes.NewReader(corrupted))
+	require.EqualError(t, err, ErrChecksumMismatch.Error())
+	require.Equal(t, 1, len(msgs))
+
+	_, err = ReplayLog(bytes.NewReader(data[:len(data)-1]))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestSubscriptionLogCorruptedLength(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 1, "sport/tennis")))
+	first := buf.Len()
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 2, "weather/#")))
+
+	data := buf.Bytes()
+
+	// huge lengths are rejected before body is allocated
+	for _, l := range []uint32{0, 0x7FFFFFFF, 0xFFFFFFFF, uint32(logRecordMaxLen() + 1)} {
+		corrupted := make([]byte, len(data))
+		copy(corrupted, data)
+		binary.BigEndian.PutUint32(corrupted[first:], l)
+
+		msgs, err := ReplayLog(bytes.NewReader(corrupted))
+		require.EqualError(t, err, ErrInvalidLength.Error(), "length %d", l)
+		require.Equal(t, 1, len(msgs))
+	}
+}
+
+func TestSubscriptionLogMaxRecordLength(t *testing.T) {
+	require.NoError(t, SetMaxRemainingLength(SUBSCRIBE, 32))
+	defer SetMaxRemainingLength(SUBSCRIBE, 0) // nolint: errcheck
+
+	// version, fixed header of 2 bytes and remaining length limit
+	require.Equal(t, 1+2+32, logRecordMaxLen())
+
+	var buf bytes.Buffer
+
+	require.NoError(t, AppendToLog(&buf, newLogSubscribe(t, ProtocolV311, 1, "sport/tennis")))
+
+	// record Decode would reject is not written to the log
+	msg := newLogSubscribe(t, ProtocolV311, 2, "sport/tennis/player1/ranking/#")
+	require.EqualError(t, AppendToLog(&buf, msg), ErrInvalidLength.Error())
+
+	data := buf.Bytes()
+
+	binary.BigEndian.PutUint32(data, uint32(logRecordMaxLen()+1))
+	_, err := ReplayLog(bytes.NewReader(data))
+	require.EqualError(t, err, ErrInvalidLength.Error())
+}
+
+func TestSubscriptionLogConcurrentAppend(t *testing.T) {
+	msg := newLogSubscribe(t, ProtocolV311, 1, "sport/tennis")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			msg.AddTopic("a/"+strconv.Itoa(i), SubscriptionOptions(QoS1)) // nolint: errcheck
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+
+		require.NoError(t, AppendToLog(&buf, msg))
+
+		msgs, err := ReplayLog(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(msgs))
+	}
+
+	<-done
+}
+
+// AssertPersistRoundTrip writes message to subscription log, replays it back
+// and asserts all subscription fields survived
+func AssertPersistRoundTrip(t *testing.T, msg *Subscribe) {
+	var buf bytes.Buffer
+
+	require.NoError(t, AppendToLog(&buf, msg))
+
+	msgs, err := ReplayLog(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(msgs))
+
+	restored := msgs[0]
+	require.Equal(t, msg.Version(), restored.Version())
+	require.Equal(t, msg.Freeze(), restored.Freeze())
+
+	subID, subIDOk := msg.SubscriptionIdentifier()
+	restoredID, restoredIDOk := restored.SubscriptionIdentifier()
+	require.Equal(t, subIDOk, restoredIDOk)
+	require.Equal(t, subID, restoredID)
+	require.Equal(t, msg.UserProperties(), restored.UserProperties())
+}
+
+func TestSubscriptionLogPersistRoundTrip(t *testing.T) {
+	AssertPersistRoundTrip(t, newLogSubscribe(t, ProtocolV311, 1, "sport/tennis"))
+
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(9)
+	require.NoError(t, msg.SetSubscriptionIdentifier(1000))
+	require.NoError(t, msg.AddUserProperty("k", "v"))
+
+	for rh := RetainHandlingRetain; rh <= RetainHandlingDoNotRetain; rh++ {
+		require.NoError(t, msg.AddTopic("a/"+strconv.Itoa(int(rh)), NewSubscriptionOptions(QoS2, true, true, rh)))
+	}
+
+	require.NoError(t, msg.AddTopic("$share/g/b/#", NewSubscriptionOptions(QoS1, false, true, RetainHandlingIfNotExists)))
+
+	AssertPersistRoundTrip(t, msg)
+}
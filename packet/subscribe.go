@@ -15,7 +15,16 @@
 package packet
 
 import (
-	"unicode/utf8"
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
 )
 
 // Subscribe The SUBSCRIBE Packet is sent from the Client to the Server to create one or more
@@ -24,32 +33,795 @@ import (
 // Application Messages that were published to Topics that match these Subscriptions.
 // The SUBSCRIBE Packet also specifies (for each Subscription) the maximum QoS with
 // which the Server can send Application Messages to the Client.
+//
+// Exported methods are safe for concurrent use, so message can be encoded while another
+// goroutine still adds topics. Packet type is fixed at creation, thus Type, Name and Desc need no locking.
+// Decode into message being used concurrently is not allowed
 type Subscribe struct {
 	header
+	lock   sync.RWMutex
 	topics []string
 	ops    []SubscriptionOptions
+	stats  []SubStats
+	wLock  sync.Mutex
+	wBuf   []byte
+}
+
+// SubStats delivery statistics of single subscription
+// It is broker internal data and is never encoded
+type SubStats struct {
+	Delivered uint64
 }
 
 var _ Provider = (*Subscribe)(nil)
+var _ encoding.BinaryMarshaler = (*Subscribe)(nil)
+var _ encoding.BinaryUnmarshaler = (*Subscribe)(nil)
+var _ io.WriterTo = (*Subscribe)(nil)
+
+// SingleSubscribe single subscription of SUBSCRIBE message along with packet ID
+// and position of subscription within parent message
+type SingleSubscribe struct {
+	Topic    string
+	Options  SubscriptionOptions
+	PacketID IDType
+	Index    int
+}
+
+// FrozenSubscribe read-only snapshot of SUBSCRIBE message safe to share between goroutines
+type FrozenSubscribe struct {
+	topics   []string
+	ops      []SubscriptionOptions
+	packetID IDType
+	hasID    bool
+}
+
+// maxSubscriptionIdentifier v5.0 [MQTT-3.8.2.1.2] maximum value of variable byte integer
+const maxSubscriptionIdentifier = 268435455
 
 func newSubscribe() *Subscribe {
 	return &Subscribe{}
 }
 
-// RangeTopics loop through list of topics
-func (msg *Subscribe) RangeTopics(fn func(string, SubscriptionOptions)) {
-	for i, t := range msg.topics {
-		fn(t, msg.ops[i])
-	}
+// CoalesceSubscribes merges subscriptions from messages into single SUBSCRIBE with given packet ID.
+// Topics keep order of first appearance and subscription options of last one.
+// All messages must be of same protocol version
+func CoalesceSubscribes(id IDType, msgs []*Subscribe) (*Subscribe, error) {
+	if len(msgs) == 0 || msgs[0] == nil {
+		return nil, ErrInvalidArgs
+	}
+
+	m, err := New(msgs[0].Version(), SUBSCRIBE)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, _ := m.(*Subscribe)
+	msg.SetPacketID(id)
+
+	index := make(map[string]int)
+
+	for _, s := range msgs {
+		if s == nil {
+			return nil, ErrInvalidArgs
+		}
+
+		// snapshot each input under its own lock, inputs may be modified concurrently
+		s.lock.RLock()
+		version := s.version
+		topics := append([]string(nil), s.topics...)
+		ops := append([]SubscriptionOptions(nil), s.ops...)
+		s.lock.RUnlock()
+
+		if version != msg.version {
+			return nil, ErrInvalidArgs
+		}
+
+		for i, t := range topics {
+			if idx, ok := index[t]; ok {
+				msg.ops[idx] = ops[i]
+				continue
+			}
+
+			index[t] = len(msg.topics)
+			msg.topics = append(msg.topics, t)
+			msg.ops = append(msg.ops, ops[i])
+		}
+	}
+
+	return msg, nil
+}
+
+// Freeze returns snapshot of subscriptions and packet ID independent of further message modifications
+func (msg *Subscribe) Freeze() FrozenSubscribe {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	f := FrozenSubscribe{
+		topics: make([]string, len(msg.topics)),
+		ops:    make([]SubscriptionOptions, len(msg.ops)),
+	}
+
+	copy(f.topics, msg.topics)
+	copy(f.ops, msg.ops)
+
+	if id, err := msg.header.ID(); err == nil {
+		f.packetID = id
+		f.hasID = true
+	}
+
+	return f
+}
+
+// Topics returns copy of subscribed topics
+func (f FrozenSubscribe) Topics() []string {
+	topics := make([]string, len(f.topics))
+	copy(topics, f.topics)
+	return topics
+}
+
+// Options returns copy of subscription options in order of topics
+func (f FrozenSubscribe) Options() []SubscriptionOptions {
+	ops := make([]SubscriptionOptions, len(f.ops))
+	copy(ops, f.ops)
+	return ops
+}
+
+// PacketID returns packet ID and whether it was set at the time of snapshot
+func (f FrozenSubscribe) PacketID() (IDType, bool) {
+	return f.packetID, f.hasID
+}
+
+// Clone returns deep copy of the message, including packet ID and properties,
+// which is not affected by further modifications of original, e.g. to keep it in retry queue
+func (msg *Subscribe) Clone() *Subscribe {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	m, _ := New(msg.version, SUBSCRIBE)
+	c, _ := m.(*Subscribe)
+
+	c.topics = make([]string, len(msg.topics))
+	copy(c.topics, msg.topics)
+
+	c.ops = make([]SubscriptionOptions, len(msg.ops))
+	copy(c.ops, msg.ops)
+
+	if len(msg.packetID) > 0 {
+		c.packetID = append([]byte(nil), msg.packetID...)
+	}
+
+	if len(msg.stats) > 0 {
+		c.stats = append([]SubStats(nil), msg.stats...)
+	}
+
+	for id, val := range msg.properties.properties {
+		if pairs, ok := val.([]StringPair); ok {
+			val = append([]StringPair(nil), pairs...)
+		}
+
+		c.properties.Set(SUBSCRIBE, id, val) // nolint: errcheck
+	}
+
+	return c
+}
+
+// Equal check if other has same protocol version, packet ID and subscriptions in same order.
+// Properties and delivery stats are not compared
+func (msg *Subscribe) Equal(other *Subscribe) bool {
+	if msg == other {
+		return true
+	}
+
+	if other == nil {
+		return false
+	}
+
+	// snapshot other first so both locks are never held at once
+	other.lock.RLock()
+	version := other.version
+	packetID := append([]byte(nil), other.packetID...)
+	topics := append([]string(nil), other.topics...)
+	ops := append([]SubscriptionOptions(nil), other.ops...)
+	other.lock.RUnlock()
+
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	if msg.version != version || !bytes.Equal(msg.packetID, packetID) || len(msg.topics) != len(topics) {
+		return false
+	}
+
+	for i, t := range msg.topics {
+		if t != topics[i] || msg.ops[i] != ops[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SplitByQoS partitions subscriptions into separate messages per QoS level.
+// Each message gets packet ID from nextID and copy of v5 properties
+func (msg *Subscribe) SplitByQoS(nextID func() IDType) map[QosType]*Subscribe {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	res := make(map[QosType]*Subscribe)
+
+	for i, t := range msg.topics {
+		q := msg.ops[i].QoS()
+
+		sub, ok := res[q]
+		if !ok {
+			m, _ := New(msg.version, SUBSCRIBE)
+			sub, _ = m.(*Subscribe)
+			sub.SetPacketID(nextID())
+
+			for id, val := range msg.properties.properties {
+				sub.properties.Set(SUBSCRIBE, id, val) // nolint: errcheck
+			}
+
+			res[q] = sub
+		}
+
+		sub.topics = append(sub.topics, t)
+		sub.ops = append(sub.ops, msg.ops[i])
+	}
+
+	return res
+}
+
+// Topics returns copy of topics in the message
+// Decoded topics are copied from the source buffer thus it can be reused once Decode returns
+func (msg *Subscribe) Topics() []string {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	topics := make([]string, len(msg.topics))
+	copy(topics, msg.topics)
+	return topics
+}
+
+// Fan splits message into single subscriptions to process each of them independently
+// Order of subscriptions is preserved
+func (msg *Subscribe) Fan() []*SingleSubscribe {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	id, _ := msg.header.ID()
+
+	res := make([]*SingleSubscribe, 0, len(msg.topics))
+
+	for i, t := range msg.topics {
+		res = append(res, &SingleSubscribe{
+			Topic:    t,
+			Options:  msg.ops[i],
+			PacketID: id,
+			Index:    i,
+		})
+	}
+
+	return res
+}
+
+// TopicCount returns amount of topics in the message
+// SUBSCRIBE decode is always eager: each topic filter is validated before SUBACK can be sent,
+// thus there is no lazy mode to scan and count is the length of decoded list
+func (msg *Subscribe) TopicCount() int {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return len(msg.topics)
+}
+
+// TopicAt returns topic and subscription options at index i
+// ok is false if i is out of range
+func (msg *Subscribe) TopicAt(i int) (string, SubscriptionOptions, bool) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	if i < 0 || i >= len(msg.topics) {
+		return "", 0, false
+	}
+
+	return msg.topics[i], msg.ops[i], true
+}
+
+// DistinctLevels returns amount of unique level strings across all topic filters
+// It may be used to estimate size of subscriptions trie
+func (msg *Subscribe) DistinctLevels() int {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	levels := make(map[string]bool)
+
+	for _, t := range msg.topics {
+		c := NewLevelCursor(t)
+		for level, ok := c.Next(); ok; level, ok = c.Next() {
+			levels[level] = true
+		}
+	}
+
+	return len(levels)
+}
+
+// HasCatchAll check if any of subscriptions is bare multi-level wildcard
+func (msg *Subscribe) HasCatchAll() bool {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for _, t := range msg.topics {
+		if IsCatchAll(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RangeTopics loop through list of topics
+// fn is called over snapshot of subscriptions thus it may modify the message
+func (msg *Subscribe) RangeTopics(fn func(string, SubscriptionOptions)) {
+	msg.lock.RLock()
+	topics := append([]string(nil), msg.topics...)
+	ops := append([]SubscriptionOptions(nil), msg.ops...)
+	msg.lock.RUnlock()
+
+	for i, t := range topics {
+		fn(t, ops[i])
+	}
+}
+
+// RangeReverse loop through list of topics from last added to first one
+// Iteration stops when fn returns false
+func (msg *Subscribe) RangeReverse(fn func(string, SubscriptionOptions) bool) {
+	msg.lock.RLock()
+	topics := append([]string(nil), msg.topics...)
+	ops := append([]SubscriptionOptions(nil), msg.ops...)
+	msg.lock.RUnlock()
+
+	for i := len(topics) - 1; i >= 0; i-- {
+		if !fn(topics[i], ops[i]) {
+			return
+		}
+	}
+}
+
+// AnyMatch check if any of subscriptions in the message matches topic name.
+// Shared subscriptions are matched by their topic filter.
+// Topic levels are compared in place thus call does not allocate
+func (msg *Subscribe) AnyMatch(topic string) bool {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for _, t := range msg.topics {
+		if _, filter, ok := ParseSharedSubscription(t); ok {
+			t = filter
+		}
+
+		if TopicMatches(t, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DescribeSubscriptions returns human readable description of each subscription
+// in form "topic (QoS n)"
+func (msg *Subscribe) DescribeSubscriptions() []string {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	res := make([]string, 0, len(msg.topics))
+
+	for i, t := range msg.topics {
+		res = append(res, t+" (QoS "+strconv.Itoa(int(msg.ops[i].QoS()))+")")
+	}
+
+	return res
+}
+
+// ExpectedSubAckSize returns size of encoded SUBACK without properties responding to this message
+// for given protocol version. It allows to pre-size write buffers before SUBACK is built
+func (msg *Subscribe) ExpectedSubAckSize(v ProtocolVersion) int {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	// packet ID and return code per topic
+	remLen := 2 + len(msg.topics)
+
+	// v5.0 empty properties
+	if v == ProtocolV50 {
+		remLen++
+	}
+
+	return 1 + uvarintCalc(uint32(remLen)) + remLen
+}
+
+// SubscriptionKeys returns canonical key of each subscription suitable for map storage
+// v5 keys include all subscription options, e.g. sport/tennis|q1|nl0|rap0|rh0
+func (msg *Subscribe) SubscriptionKeys() []string {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.subscriptionKeys()
+}
+
+func (msg *Subscribe) subscriptionKeys() []string {
+	boolKey := func(v bool) string {
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+
+	keys := make([]string, 0, len(msg.topics))
+
+	for i, t := range msg.topics {
+		ops := msg.ops[i]
+		key := t + "|q" + strconv.Itoa(int(ops.QoS()))
+
+		if msg.version == ProtocolV50 {
+			key += "|nl" + boolKey(ops.NL()) +
+				"|rap" + boolKey(ops.RAP()) +
+				"|rh" + strconv.Itoa(int(ops.RetainHandling()))
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// IdempotencyKey returns hex encoded digest of subscriptions set which does not depend
+// on packet ID and order of topics, thus retried subscribe produces same key
+func (msg *Subscribe) IdempotencyKey() string {
+	msg.lock.RLock()
+	keys := msg.subscriptionKeys()
+	msg.lock.RUnlock()
+
+	sort.Strings(keys)
+
+	h := sha256.New()
+
+	for i, k := range keys {
+		if i > 0 && k == keys[i-1] {
+			continue
+		}
+
+		// topic filters cannot contain null character thus it is safe separator
+		h.Write([]byte(k)) // nolint: errcheck
+		h.Write([]byte{0}) // nolint: errcheck
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BuildExactSet returns map of subscribed topic names without wildcards to theirs QoS
+// Shared subscriptions are put by theirs topic filter. It allows to check exact subscriptions
+// in constant time before falling back to AnyMatch
+func (msg *Subscribe) BuildExactSet() map[string]QosType {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	set := make(map[string]QosType)
+
+	for i, t := range msg.topics {
+		if _, filter, ok := ParseSharedSubscription(t); ok {
+			t = filter
+		}
+
+		if ValidTopic(t) {
+			set[t] = msg.ops[i].QoS()
+		}
+	}
+
+	return set
+}
+
+// ChurnStats compares subscriptions against previous snapshot and returns amount of topics
+// added, removed and topics with changed subscription options
+func (msg *Subscribe) ChurnStats(prev *Subscribe) (added, removed, changed int) {
+	// prev is snapshot under own lock so both messages are never locked at once
+	before := make(map[string]SubscriptionOptions)
+	if prev != nil {
+		prev.lock.RLock()
+		for i, t := range prev.topics {
+			before[t] = prev.ops[i]
+		}
+		prev.lock.RUnlock()
+	}
+
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	current := make(map[string]bool)
+
+	for i, t := range msg.topics {
+		current[t] = true
+		if ops, ok := before[t]; !ok {
+			added++
+		} else if ops != msg.ops[i] {
+			changed++
+		}
+	}
+
+	for t := range before {
+		if !current[t] {
+			removed++
+		}
+	}
+
+	return added, removed, changed
+}
+
+// WarnSharedOverlap returns filters subscribed both as shared and non-shared subscriptions
+// within same message. Such subscriptions are valid but often unintended
+func (msg *Subscribe) WarnSharedOverlap() []string {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.warnSharedOverlap()
+}
+
+func (msg *Subscribe) warnSharedOverlap() []string {
+	exclusive := make(map[string]bool)
+	for _, t := range msg.topics {
+		if _, _, ok := ParseSharedSubscription(t); !ok {
+			exclusive[t] = true
+		}
+	}
+
+	var overlap []string
+	reported := make(map[string]bool)
+
+	for _, t := range msg.topics {
+		if _, filter, ok := ParseSharedSubscription(t); ok && exclusive[filter] && !reported[filter] {
+			reported[filter] = true
+			overlap = append(overlap, filter)
+		}
+	}
+
+	return overlap
+}
+
+// DeadFilters returns subscriptions whose root level does not match root level of any
+// known published topic prefix. Result is advisory and meant for tooling only as
+// broker may start publishing into new namespaces at any time
+func (msg *Subscribe) DeadFilters(publishedPrefixes []string) []string {
+	roots := make(map[string]bool)
+	nonSystem := false
+
+	for _, p := range publishedPrefixes {
+		root, _, _ := splitTopicLevel(p)
+		roots[root] = true
+
+		if !IsSystemTopic(p) {
+			nonSystem = true
+		}
+	}
+
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	var dead []string
+
+	for _, t := range msg.topics {
+		filter := t
+		if _, f, ok := ParseSharedSubscription(t); ok {
+			filter = f
+		}
+
+		root, _, _ := splitTopicLevel(filter)
+
+		switch root {
+		case topicMultiWildcard, topicSingleWildcard:
+			// [MQTT-4.7.2-1] wildcard does not match topics starting with $
+			if !nonSystem {
+				dead = append(dead, t)
+			}
+		default:
+			if !roots[root] {
+				dead = append(dead, t)
+			}
+		}
+	}
+
+	return dead
+}
+
+// ShareAll converts every subscription into shared subscription of given group.
+// Topics already shared are moved into the group. Message is not modified on error
+func (msg *Subscribe) ShareAll(group string) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	topics := make([]string, len(msg.topics))
+
+	for i, t := range msg.topics {
+		if _, filter, ok := ParseSharedSubscription(t); ok {
+			t = filter
+		}
+
+		// v5.0 [MQTT-3.8.3-4]
+		if msg.version == ProtocolV50 && msg.ops[i].NL() {
+			return ErrProtocolViolation
+		}
+
+		shared, err := MakeShared(group, t)
+		if err != nil {
+			return err
+		}
+
+		topics[i] = shared
+	}
+
+	msg.topics = topics
+
+	return nil
+}
+
+// InvalidTopicFilter returns first filter not passing ValidTopicFilter with given policies
+// Used to enforce broker policies on top of spec rules, e.g. bound matching cost with MaxWildcards
+func (msg *Subscribe) InvalidTopicFilter(opts TopicFilterOptions) (string, bool) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for _, t := range msg.topics {
+		if !ValidTopicFilter(t, opts) {
+			return t, true
+		}
+	}
+
+	return "", false
+}
+
+// HasSharedExclusiveConflict returns first filter subscribed both as shared and non-shared subscription.
+// Used to enforce policies where such mixing is not allowed
+func (msg *Subscribe) HasSharedExclusiveConflict() (string, bool) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	if overlap := msg.warnSharedOverlap(); len(overlap) > 0 {
+		return overlap[0], true
+	}
+
+	return "", false
+}
+
+// AddTopic adds a single topic to the message, along with the corresponding QoS.
+// An error is returned if QoS is invalid.
+func (msg *Subscribe) AddTopic(topic string, ops SubscriptionOptions) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	if err := msg.validateSubscription(topic, ops); err != nil {
+		return err
+	}
+
+	msg.topics = append(msg.topics, topic)
+	msg.ops = append(msg.ops, ops)
+
+	return nil
+}
+
+// AddTopics adds subscriptions to the message in one pass.
+// All entries are validated before any is added, on failure message is left unchanged and
+// *SubscriptionError describing first invalid entry is returned.
+// Topic repeated in batch or already present in the message is replaced with the last options given for it
+func (msg *Subscribe) AddTopics(topics []string, ops []SubscriptionOptions) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	if len(topics) != len(ops) {
+		return ErrInvalidArgs
+	}
+
+	for i, t := range topics {
+		if err := msg.validateSubscription(t, ops[i]); err != nil {
+			return &SubscriptionError{Index: i, Topic: t, Err: err}
+		}
+	}
+
+	index := make(map[string]int, len(msg.topics)+len(topics))
+	for i, t := range msg.topics {
+		if _, ok := index[t]; !ok {
+			index[t] = i
+		}
+	}
+
+	for i, t := range topics {
+		if j, ok := index[t]; ok {
+			msg.ops[j] = ops[i]
+			continue
+		}
+
+		index[t] = len(msg.topics)
+		msg.topics = append(msg.topics, t)
+		msg.ops = append(msg.ops, ops[i])
+	}
+
+	return nil
+}
+
+// AppendTopic adds subscription like AddTopic and appends its encoding to dst holding
+// the message encoded before the call. Remaining length in the fixed header of dst is
+// updated in place so message can be built incrementally without full re-encode.
+// Message and dst are left unchanged on error
+func (msg *Subscribe) AppendTopic(dst []byte, topic string, ops SubscriptionOptions) ([]byte, error) {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	if err := msg.validateSubscription(topic, ops); err != nil {
+		return dst, err
+	}
+
+	if len(topic) > MaxLPString {
+		return dst, ErrInvalidLPStringSize
+	}
+
+	if len(dst) < 2 {
+		return dst, ErrInsufficientDataSize
+	}
+
+	if Type(dst[0]>>offsetPacketType) != SUBSCRIBE {
+		return dst, ErrInvalidMessageType
+	}
+
+	remLen, n := uvarint(dst[1:])
+	if n <= 0 || 1+n+int(remLen) != len(dst) {
+		return dst, ErrInvalidLength
+	}
+
+	entry := 2 + len(topic) + 1
+
+	newRemLen := remLen + uint32(entry)
+	if newRemLen > uint32(maxRemainingLength) {
+		return dst, ErrInvalidLength
+	}
+
+	// remaining length may take more bytes, shift variable header and payload to make room
+	newN := uvarintCalc(newRemLen)
+
+	buf := dst
+	if grow := newN - n + entry; cap(buf)-len(buf) < grow {
+		buf = make([]byte, len(dst), len(dst)+grow+len(dst)/2)
+		copy(buf, dst)
+	}
+
+	buf = buf[:len(dst)+newN-n]
+	if newN != n {
+		copy(buf[1+newN:], buf[1+n:len(dst)])
+	}
+
+	binary.PutUvarint(buf[1:], uint64(newRemLen))
+
+	offset := len(buf)
+	buf = buf[:offset+entry]
+
+	binary.BigEndian.PutUint16(buf[offset:], uint16(len(topic)))
+	offset += 2
+	offset += copy(buf[offset:], topic)
+	buf[offset] = byte(ops)
+
+	msg.topics = append(msg.topics, topic)
+	msg.ops = append(msg.ops, ops)
+
+	return buf, nil
 }
 
-// AddTopic adds a single topic to the message, along with the corresponding QoS.
-// An error is returned if QoS is invalid.
-func (msg *Subscribe) AddTopic(topic string, ops SubscriptionOptions) error {
+func (msg *Subscribe) validateSubscription(topic string, ops SubscriptionOptions) error {
 	if msg.version == ProtocolV50 {
-		if byte(ops)&maskSubscriptionReserved != 0 {
+		// [MQTT-3.8.3.1] retain handling of 3 is protocol error
+		if byte(ops)&maskSubscriptionReserved != 0 || ops.RetainHandling() > RetainHandlingDoNotRetain {
 			return ErrInvalidArgs
 		}
+
+		// v5.0 [MQTT-3.8.3-4] No Local is not allowed on shared subscriptions
+		if _, _, shared := ParseSharedSubscription(topic); shared && ops.NL() {
+			return ErrProtocolViolation
+		}
 	} else {
 		if !QosType(ops).IsValid() {
 			return ErrInvalidQoS
@@ -57,23 +829,599 @@ func (msg *Subscribe) AddTopic(topic string, ops SubscriptionOptions) error {
 	}
 
 	// [MQTT-3.8.3-1]
-	if !utf8.Valid([]byte(topic)) {
+	if !validTopicEncoding(topic) {
 		return ErrMalformedTopic
 	}
 
-	msg.topics = append(msg.topics, topic)
-	msg.ops = append(msg.ops, ops)
+	return checkSubscriptionFilter(topic)
+}
+
+// checkSubscriptionFilter check topic filter of subscription, shared subscriptions
+// are checked for share name and theirs topic filter
+func checkSubscriptionFilter(topic string) error {
+	filter := topic
+	if name, f, shared := ParseSharedSubscription(topic); shared {
+		if !validShareName(name) {
+			return ErrInvalidTopic
+		}
+
+		filter = f
+	}
+
+	// [MQTT-4.7.3-1]
+	if len(filter) == 0 {
+		return ErrInvalidTopic
+	}
+
+	return checkTopicWildcards(filter)
+}
+
+// Validate checks message has packet ID set and at least one valid subscription
+// Invalid subscription is reported as *SubscriptionError
+func (msg *Subscribe) Validate() error {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	// [MQTT-2.3.1-1]
+	if id, err := msg.header.ID(); err != nil || id == 0 {
+		return ErrPackedIDZero
+	}
+
+	// [MQTT-3.8.3-3]
+	if len(msg.topics) == 0 {
+		return ErrEmptyPayload
+	}
+
+	for i, t := range msg.topics {
+		if err := msg.validateSubscription(t, msg.ops[i]); err != nil {
+			return &SubscriptionError{Index: i, Topic: t, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// RemoveTopic removes subscription to topic from the message
+// Returns false if message has no such topic
+func (msg *Subscribe) RemoveTopic(topic string) bool {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	for i, t := range msg.topics {
+		if t == topic {
+			msg.topics = append(msg.topics[:i], msg.topics[i+1:]...)
+			msg.ops = append(msg.ops[:i], msg.ops[i+1:]...)
+			if i < len(msg.stats) {
+				msg.stats = append(msg.stats[:i], msg.stats[i+1:]...)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// Shrink reallocates subscriptions to release capacity left after removals
+func (msg *Subscribe) Shrink() {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	topics := make([]string, len(msg.topics))
+	copy(topics, msg.topics)
+
+	ops := make([]SubscriptionOptions, len(msg.ops))
+	copy(ops, msg.ops)
+
+	msg.topics = topics
+	msg.ops = ops
+
+	if len(msg.stats) > 0 {
+		msg.stats = append([]SubStats(nil), msg.stats...)
+	}
+}
+
+// SortTopics stable sorts subscriptions by topic keeping options and stats attached to theirs topics
+// Messages with same set of subscriptions are encoded into same bytes once sorted
+func (msg *Subscribe) SortTopics() {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	if len(msg.stats) > 0 && len(msg.stats) < len(msg.topics) {
+		msg.stats = append(msg.stats, make([]SubStats, len(msg.topics)-len(msg.stats))...)
+	}
+
+	sort.Stable((*subscriptionsByTopic)(msg))
+}
+
+type subscriptionsByTopic Subscribe
+
+func (s *subscriptionsByTopic) Len() int           { return len(s.topics) }
+func (s *subscriptionsByTopic) Less(i, j int) bool { return s.topics[i] < s.topics[j] }
+func (s *subscriptionsByTopic) Swap(i, j int) {
+	s.topics[i], s.topics[j] = s.topics[j], s.topics[i]
+	s.ops[i], s.ops[j] = s.ops[j], s.ops[i]
+
+	if len(s.stats) > 0 {
+		s.stats[i], s.stats[j] = s.stats[j], s.stats[i]
+	}
+}
+
+// IncDelivered increments amount of messages delivered over subscription to topic
+// Returns false if message has no such topic
+func (msg *Subscribe) IncDelivered(topic string) bool {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	for i, t := range msg.topics {
+		if t == topic {
+			// stats allocated on first use and kept aligned with topics
+			if len(msg.stats) < len(msg.topics) {
+				msg.stats = append(msg.stats, make([]SubStats, len(msg.topics)-len(msg.stats))...)
+			}
+
+			msg.stats[i].Delivered++
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stats returns delivery statistics of subscription to topic
+func (msg *Subscribe) Stats(topic string) (SubStats, bool) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for i, t := range msg.topics {
+		if t == topic {
+			if i < len(msg.stats) {
+				return msg.stats[i], true
+			}
+
+			return SubStats{}, true
+		}
+	}
+
+	return SubStats{}, false
+}
+
+// DowngradeUnsupportedQoS lowers QoS of subscriptions above maxSupported keeping other subscription options.
+// It is capability of the delivery engine and is separate to maximum QoS negotiated with client.
+// Returns true if any of subscriptions has been changed
+func (msg *Subscribe) DowngradeUnsupportedQoS(maxSupported QosType) bool {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	changed := false
+
+	for i, ops := range msg.ops {
+		if ops.QoS() > maxSupported {
+			msg.ops[i] = SubscriptionOptions(byte(ops)&^maskSubscriptionQoS | byte(maxSupported))
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// MinProtocolVersion returns ProtocolV50 if any of subscription options beyond QoS or properties are set
+func (msg *Subscribe) MinProtocolVersion() ProtocolVersion {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for _, ops := range msg.ops {
+		if byte(ops)&^maskSubscriptionQoS != 0 {
+			return ProtocolV50
+		}
+	}
+
+	return msg.header.MinProtocolVersion()
+}
+
+// SubscriptionIdentifier returns v5 Subscription Identifier and whether it is set
+func (msg *Subscribe) SubscriptionIdentifier() (uint32, bool) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.subscriptionIdentifier()
+}
+
+func (msg *Subscribe) subscriptionIdentifier() (uint32, bool) {
+	if prop := msg.header.PropertyGet(PropertySubscriptionIdentifier); prop != nil {
+		if v, err := prop.AsInt(); err == nil {
+			return v, true
+		}
+	}
+
+	return 0, false
+}
+
+// SetSubscriptionIdentifier set v5 Subscription Identifier
+// Error returned if id is 0 or does not fit into variable byte integer
+func (msg *Subscribe) SetSubscriptionIdentifier(id uint32) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	// v5.0 [MQTT-3.8.2.1.2]
+	if id == 0 || id > maxSubscriptionIdentifier {
+		return ErrInvalidArgs
+	}
+
+	return msg.header.PropertySet(PropertySubscriptionIdentifier, id)
+}
+
+// CheckSubscriptionIDAllowed check Subscription Identifier is not set unless server
+// announced Subscription Identifiers are available in CONNACK
+func (msg *Subscribe) CheckSubscriptionIDAllowed(available bool) error {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	// v5.0 [MQTT-3.2.2.3.16]
+	if _, ok := msg.subscriptionIdentifier(); ok && !available {
+		return CodeSubscriptionIDNotSupported
+	}
 
 	return nil
 }
 
+// UserProperties returns v5 user properties in order of appearance
+func (msg *Subscribe) UserProperties() []StringPair {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.userProperties()
+}
+
+// AddUserProperty append v5 user property. Duplicate keys are allowed and order is preserved
+func (msg *Subscribe) AddUserProperty(key, value string) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	return msg.addUserProperty(key, value)
+}
+
 // SetPacketID sets the ID of the packet.
 func (msg *Subscribe) SetPacketID(v IDType) {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
 	msg.setPacketID(v)
 }
 
+// ID returns packet ID
+func (msg *Subscribe) ID() (IDType, error) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.ID()
+}
+
+// Version get protocol version used by message
+func (msg *Subscribe) Version() ProtocolVersion {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.version
+}
+
+// SetVersion set protocol version used by message
+func (msg *Subscribe) SetVersion(v ProtocolVersion) {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	msg.header.SetVersion(v)
+}
+
+// PropertyGet get v5 property
+func (msg *Subscribe) PropertyGet(id PropertyID) PropertyToType {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.PropertyGet(id)
+}
+
+// PropertySet set v5 property
+func (msg *Subscribe) PropertySet(id PropertyID, val interface{}) error {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	return msg.header.PropertySet(id, val)
+}
+
+// PropertyForEach iterate over v5 properties
+// f must not modify the message
+func (msg *Subscribe) PropertyForEach(f func(PropertyID, PropertyToType)) error {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.PropertyForEach(f)
+}
+
+// Encode message into to holding read lock so concurrent modifications cannot change
+// subscriptions between size calculation and encode
+func (msg *Subscribe) Encode(to []byte) (int, error) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.Encode(to)
+}
+
+// EncodeInto encode message into arena at given offset
+func (msg *Subscribe) EncodeInto(arena []byte, offset int) (int, error) {
+	if offset < 0 || offset > len(arena) {
+		return 0, ErrInvalidArgs
+	}
+
+	return msg.Encode(arena[offset:])
+}
+
+// Size of message
+func (msg *Subscribe) Size() (int, error) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.Size()
+}
+
+// RemainingLength returns the length of the non-fixed-header part of the message.
+func (msg *Subscribe) RemainingLength() int32 {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.RemainingLength()
+}
+
+// CheckRemainingLength verifies remaining length computed from current content of the message can be sent.
+func (msg *Subscribe) CheckRemainingLength() error {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.CheckRemainingLength()
+}
+
+// EncodeHeader writes fixed header with given remaining length
+func (msg *Subscribe) EncodeHeader(to []byte, remLen int32) (int, error) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.EncodeHeader(to, remLen)
+}
+
+// Flags returns the fixed header flags for this message.
+func (msg *Subscribe) Flags() byte {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.Flags()
+}
+
+// SizeDeltaIfAdd returns change of encoded message size if subscription to topic is added
+// Growth of remaining length field is accounted
+func (msg *Subscribe) SizeDeltaIfAdd(topic string) int {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.sizeDelta(2 + len(topic) + 1)
+}
+
+// SizeDeltaIfRemove returns change of encoded message size if subscription to topic is removed
+// 0 returned if message has no such topic
+func (msg *Subscribe) SizeDeltaIfRemove(topic string) int {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	for _, t := range msg.topics {
+		if t == topic {
+			return msg.sizeDelta(-(2 + len(topic) + 1))
+		}
+	}
+
+	return 0
+}
+
+func (msg *Subscribe) sizeDelta(payload int) int {
+	remLen := msg.size()
+	newRemLen := remLen + payload
+
+	before := msg.remLenSize(int32(remLen)) + remLen
+	after := uvarintCalc(uint32(newRemLen)) + newRemLen
+
+	return after - before
+}
+
+// WriteTo encode message into buffer reused across calls and write it into w
+// It implements io.WriterTo. Modifications of the message are blocked during encode only
+func (msg *Subscribe) WriteTo(w io.Writer) (int64, error) {
+	msg.wLock.Lock()
+	defer msg.wLock.Unlock()
+
+	msg.lock.RLock()
+
+	sz, err := msg.header.Size()
+	if err == nil {
+		if cap(msg.wBuf) < sz {
+			msg.wBuf = make([]byte, sz)
+		}
+
+		sz, err = msg.header.Encode(msg.wBuf[:sz])
+	}
+
+	msg.lock.RUnlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(msg.wBuf[:sz])
+
+	return int64(n), err
+}
+
+type subscriptionJSON struct {
+	Topic             string         `json:"topic"`
+	QoS               QosType        `json:"qos"`
+	NoLocal           bool           `json:"noLocal,omitempty"`
+	RetainAsPublished bool           `json:"retainAsPublished,omitempty"`
+	RetainHandling    RetainHandling `json:"retainHandling,omitempty"`
+}
+
+type subscribeJSON struct {
+	Type          string             `json:"type"`
+	PacketID      IDType             `json:"packetId"`
+	Subscriptions []subscriptionJSON `json:"subscriptions"`
+}
+
+// MarshalJSON represents message as JSON for structured logging
+// v5 subscription options are present only when set
+func (msg *Subscribe) MarshalJSON() ([]byte, error) {
+	msg.lock.RLock()
+
+	id, _ := msg.header.ID()
+
+	v := subscribeJSON{
+		Type:          msg.Type().Name(),
+		PacketID:      id,
+		Subscriptions: make([]subscriptionJSON, len(msg.topics)),
+	}
+
+	for i, t := range msg.topics {
+		ops := msg.ops[i]
+
+		v.Subscriptions[i] = subscriptionJSON{
+			Topic:             t,
+			QoS:               ops.QoS(),
+			NoLocal:           ops.NL(),
+			RetainAsPublished: ops.RAP(),
+			RetainHandling:    ops.RetainHandling(),
+		}
+	}
+
+	msg.lock.RUnlock()
+
+	return json.Marshal(v)
+}
+
+// MarshalBinary encode message into newly allocated buffer
+func (msg *Subscribe) MarshalBinary() ([]byte, error) {
+	msg.lock.RLock()
+	defer msg.lock.RUnlock()
+
+	return msg.header.MarshalBinary()
+}
+
+// UnmarshalBinary replaces content of the message with SUBSCRIBE decoded from data
+// Message must be allocated with New as protocol version defines wire format.
+// data must hold exactly one packet
+func (msg *Subscribe) UnmarshalBinary(data []byte) error {
+	msg.lock.RLock()
+	v := msg.version
+	ok := msg.cb.decode != nil
+	msg.lock.RUnlock()
+
+	if !ok {
+		return ErrInvalidProtocolVersion
+	}
+
+	m, n, err := Decode(v, data)
+	if err != nil {
+		return err
+	}
+
+	sub, ok := m.(*Subscribe)
+	if !ok {
+		return ErrInvalidMessageType
+	}
+
+	if n != len(data) {
+		return ErrInvalidLength
+	}
+
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	// callbacks are bound to the message they were created for
+	cb := msg.cb
+	msg.header = sub.header
+	msg.cb = cb
+
+	msg.topics = sub.topics
+	msg.ops = sub.ops
+	msg.stats = nil
+
+	return nil
+}
+
+// Reset clears subscriptions, packet ID and properties keeping protocol version and
+// allocated memory, so message can be reused to decode or build another SUBSCRIBE
+func (msg *Subscribe) Reset() {
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	msg.header.reset()
+
+	for i := range msg.topics {
+		msg.topics[i] = ""
+	}
+
+	msg.topics = msg.topics[:0]
+	msg.ops = msg.ops[:0]
+	msg.stats = nil
+}
+
+var subscribePools = map[ProtocolVersion]*sync.Pool{
+	ProtocolV31:  newSubscribePool(ProtocolV31),
+	ProtocolV311: newSubscribePool(ProtocolV311),
+	ProtocolV50:  newSubscribePool(ProtocolV50),
+}
+
+func newSubscribePool(v ProtocolVersion) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			m, _ := New(v, SUBSCRIBE)
+			return m
+		},
+	}
+}
+
+// GetSubscribe returns SUBSCRIBE of given protocol version from the pool
+// Message must be returned with PutSubscribe when no longer used
+func GetSubscribe(v ProtocolVersion) (*Subscribe, error) {
+	pool, ok := subscribePools[v]
+	if !ok {
+		return nil, ErrInvalidProtocolVersion
+	}
+
+	msg, _ := pool.Get().(*Subscribe)
+
+	return msg, nil
+}
+
+// PutSubscribe resets message and returns it to the pool
+// Message must not be used after this call
+func PutSubscribe(msg *Subscribe) {
+	if pool, ok := subscribePools[msg.version]; ok {
+		msg.Reset()
+		pool.Put(msg)
+	}
+}
+
 // decode message
 func (msg *Subscribe) decodeMessage(from []byte) (int, error) {
+	rejectMalformed := CodeRefusedServerUnavailable
+	if msg.version == ProtocolV50 {
+		rejectMalformed = CodeMalformedPacket
+	}
+
+	// packet must not be read beyond remaining length
+	packetLen := int(msg.remLen)
+	if packetLen > len(from) {
+		packetLen = len(from)
+	}
+
+	if packetLen < 2 {
+		return 0, rejectMalformed
+	}
+
 	offset := msg.decodePacketID(from)
 
 	// v5 [MQTT-3.1.2.11] specifies properties in variable header
@@ -83,41 +1431,57 @@ func (msg *Subscribe) decodeMessage(from []byte) (int, error) {
 		if err != nil {
 			return offset, err
 		}
+
+		// v5.0 [MQTT-3.8.2.1.2] subscription identifier of 0 is protocol error
+		if id, ok := msg.SubscriptionIdentifier(); ok && id == 0 {
+			return offset, CodeProtocolError
+		}
 	}
 
 	remLen := int(msg.remLen) - offset
 	for remLen > 0 {
-		t, n, err := ReadLPBytes(from[offset:])
+		t, n, err := ReadLPBytes(from[offset:packetLen])
 		offset += n
 		if err != nil {
-			return offset, err
+			return offset, rejectMalformed
 		}
 
 		// [MQTT-3.8.3-1]
-		if !utf8.Valid(t) {
-			rejectReason := CodeProtocolError
-			if msg.version <= ProtocolV50 {
-				rejectReason = CodeRefusedServerUnavailable
-			}
-			return 0, rejectReason
+		if !validTopicEncoding(string(t)) {
+			return offset, rejectMalformed
+		}
+
+		// [MQTT-4.7.1-1] [MQTT-4.7.1-2] misplaced wildcards
+		if checkSubscriptionFilter(string(t)) != nil {
+			return offset, rejectMalformed
+		}
+
+		if offset >= packetLen {
+			return offset, rejectMalformed
 		}
 
+		// on error offset points to subscription options byte
 		subsOptions := SubscriptionOptions(from[offset])
-		offset++
 
-		if msg.version == ProtocolV50 && (byte(subsOptions)&maskSubscriptionReserved) != 0 {
+		// v5.0 [MQTT-3.8.3.1] retain handling of 3 is protocol error
+		if msg.version == ProtocolV50 && ((byte(subsOptions)&maskSubscriptionReserved) != 0 ||
+			subsOptions.RetainHandling() > RetainHandlingDoNotRetain) {
 			return offset, CodeProtocolError
 		}
 
 		// [MQTT-3-8.3-4]
+		// v5.0 [MQTT-3.8.3.1] maximum QoS of 3 is malformed packet
 		if !subsOptions.QoS().IsValid() {
-			rejectReason := CodeProtocolError
-			if msg.version <= ProtocolV50 {
-				rejectReason = CodeRefusedServerUnavailable
-			}
-			return offset, rejectReason
+			return offset, &QoSError{QoS: subsOptions.QoS(), Topic: string(t), Err: rejectMalformed}
+		}
+
+		// v5.0 [MQTT-3.8.3-4]
+		if _, _, shared := ParseSharedSubscription(string(t)); shared && msg.version == ProtocolV50 && subsOptions.NL() {
+			return offset, CodeProtocolError
 		}
 
+		offset++
+
 		msg.topics = append(msg.topics, string(t))
 		msg.ops = append(msg.ops, subsOptions)
 
@@ -130,7 +1494,7 @@ func (msg *Subscribe) decodeMessage(from []byte) (int, error) {
 		if msg.version <= ProtocolV50 {
 			rejectReason = CodeRefusedServerUnavailable
 		}
-		return 0, rejectReason
+		return offset, rejectReason
 	}
 
 	return offset, nil
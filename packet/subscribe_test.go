@@ -15,8 +15,15 @@
 package packet
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
 	"errors"
+	"io"
 
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,8 +43,9 @@ func TestSubscribeMessageFields(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, IDType(100), id, "Error setting packet ID.")
 
-	require.NoError(t, msg.AddTopic("/a/b/#/c", 1))
+	require.NoError(t, msg.AddTopic("/a/b/+/c", 1))
 	require.Equal(t, 1, len(msg.topics), "Error adding topic.")
+	require.Equal(t, 1, msg.TopicCount())
 }
 
 func TestSubscribeMessageDecode(t *testing.T) {
@@ -52,11 +60,11 @@ func TestSubscribeMessageDecode(t *testing.T) {
 		0, // QoS
 		0, // topic name MSB (0)
 		8, // topic name LSB (8)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c',
 		1,  // QoS
 		0,  // topic name MSB (0)
 		10, // topic name LSB (10)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c', 'd', 'd',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c', 'd', 'd',
 		2, // QoS
 	}
 
@@ -69,6 +77,7 @@ func TestSubscribeMessageDecode(t *testing.T) {
 	require.Equal(t, len(msgBytes), n, "Error decoding message.")
 	require.Equal(t, SUBSCRIBE, msg.Type(), "Error decoding message.")
 	require.Equal(t, 3, len(msg.topics), "Error decoding topics.")
+	require.Equal(t, 3, msg.TopicCount(), "Error decoding topics.")
 }
 
 // test empty topic list
@@ -85,6 +94,41 @@ func TestSubscribeMessageDecode2(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestSubscribeTopicCount(t *testing.T) {
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		12,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // topic name MSB (0)
+		1, // topic name LSB (1)
+		'a',
+		0, // QoS
+		0, // topic name MSB (0)
+		3, // topic name LSB (3)
+		'b', '/', 'c',
+		1, // QoS
+	}
+
+	m, _, err := Decode(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	require.Equal(t, 2, msg.TopicCount())
+
+	require.NoError(t, msg.AddTopic("d", 2))
+	require.Equal(t, 3, msg.TopicCount())
+
+	require.True(t, msg.RemoveTopic("a"))
+	require.Equal(t, 2, msg.TopicCount())
+
+	// malformed last topic rejects whole packet on decode, never on later access
+	msgBytes[11] = '#'
+	_, _, err = Decode(ProtocolV311, msgBytes)
+	require.Error(t, err)
+}
+
 func TestSubscribeMessageEncode(t *testing.T) {
 	msgBytes := []byte{
 		byte(SUBSCRIBE<<4) | 2,
@@ -97,11 +141,11 @@ func TestSubscribeMessageEncode(t *testing.T) {
 		0, // QoS
 		0, // topic name MSB (0)
 		8, // topic name LSB (8)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c',
 		1,  // QoS
 		0,  // topic name MSB (0)
 		10, // topic name LSB (10)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c', 'd', 'd',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c', 'd', 'd',
 		2, // QoS
 	}
 
@@ -113,8 +157,8 @@ func TestSubscribeMessageEncode(t *testing.T) {
 
 	msg.SetPacketID(7)
 	msg.AddTopic("volantmq", 0)   // nolint: errcheck
-	msg.AddTopic("/a/b/#/c", 1)   // nolint: errcheck
-	msg.AddTopic("/a/b/#/cdd", 2) // nolint: errcheck
+	msg.AddTopic("/a/b/+/c", 1)   // nolint: errcheck
+	msg.AddTopic("/a/b/+/cdd", 2) // nolint: errcheck
 
 	dst := make([]byte, 100)
 	n, err := msg.Encode(dst)
@@ -146,11 +190,11 @@ func TestSubscribeDecodeEncodeEquiv(t *testing.T) {
 		0, // QoS
 		0, // topic name MSB (0)
 		8, // topic name LSB (8)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c',
 		1,  // QoS
 		0,  // topic name MSB (0)
 		10, // topic name LSB (10)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c', 'd', 'd',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c', 'd', 'd',
 		2, // QoS
 	}
 
@@ -187,11 +231,11 @@ func TestSubscribeDecodeOrder(t *testing.T) {
 		0, // QoS
 		0, // topic name MSB (0)
 		8, // topic name LSB (8)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c',
 		1,  // QoS
 		0,  // topic name MSB (0)
 		10, // topic name LSB (10)
-		'/', 'a', '/', 'b', '/', '#', '/', 'c', 'd', 'd',
+		'/', 'a', '/', 'b', '/', '+', '/', 'c', 'd', 'd',
 		2, // QoS
 	}
 
@@ -208,10 +252,10 @@ func TestSubscribeDecodeOrder(t *testing.T) {
 			require.Equal(t, "volantmq", topic)
 			require.Equal(t, SubscriptionOptions(QoS0), ops)
 		case 1:
-			require.Equal(t, "/a/b/#/c", topic)
+			require.Equal(t, "/a/b/+/c", topic)
 			require.Equal(t, SubscriptionOptions(QoS1), ops)
 		case 2:
-			require.Equal(t, "/a/b/#/cdd", topic)
+			require.Equal(t, "/a/b/+/cdd", topic)
 			require.Equal(t, SubscriptionOptions(QoS2), ops)
 		default:
 			assert.Error(t, errors.New("Invalid topics count"))
@@ -219,3 +263,2257 @@ func TestSubscribeDecodeOrder(t *testing.T) {
 		i++
 	})
 }
+
+func TestSubscribeMinProtocolVersion(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.Equal(t, ProtocolV311, msg.MinProtocolVersion())
+
+	require.NoError(t, msg.PropertySet(PropertySubscriptionIdentifier, uint32(10)))
+	require.Equal(t, ProtocolV50, msg.MinProtocolVersion())
+
+	m, err = New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok = m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)|SubscriptionOptions(maskSubscriptionNL)))
+	require.Equal(t, ProtocolV50, msg.MinProtocolVersion())
+}
+
+func TestSubscribeEncodeInto(t *testing.T) {
+	var msgs []*Subscribe
+
+	for i, topic := range []string{"sport/tennis", "weather/#", "a/+/b"} {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(IDType(i + 1))
+		require.NoError(t, msg.AddTopic(topic, SubscriptionOptions(i)))
+
+		msgs = append(msgs, msg)
+	}
+
+	total := 0
+	for _, msg := range msgs {
+		sz, err := msg.Size()
+		require.NoError(t, err)
+		total += sz
+	}
+
+	arena := make([]byte, total)
+
+	type span struct {
+		offset int
+		length int
+	}
+
+	var spans []span
+
+	offset := 0
+	for _, msg := range msgs {
+		n, err := msg.EncodeInto(arena, offset)
+		require.NoError(t, err)
+		spans = append(spans, span{offset: offset, length: n})
+		offset += n
+	}
+
+	require.Equal(t, total, offset)
+
+	for i, sp := range spans {
+		m, n, err := Decode(ProtocolV311, arena[sp.offset:sp.offset+sp.length])
+		require.NoError(t, err)
+		require.Equal(t, sp.length, n)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Invalid message type")
+
+		id, _ := msg.ID()
+		require.Equal(t, IDType(i+1), id)
+		require.Equal(t, msgs[i].topics, msg.topics)
+		require.Equal(t, msgs[i].ops, msg.ops)
+	}
+
+	_, err := msgs[0].EncodeInto(arena, len(arena)+1)
+	require.EqualError(t, err, ErrInvalidArgs.Error())
+
+	_, err = msgs[0].EncodeInto(arena, len(arena)-1)
+	require.EqualError(t, err, ErrInsufficientBufferSize.Error())
+}
+
+func TestSubscribeChurnStats(t *testing.T) {
+	newMsg := func(topics map[string]QosType) *Subscribe {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		for topic, q := range topics {
+			require.NoError(t, msg.AddTopic(topic, SubscriptionOptions(q)))
+		}
+
+		return msg
+	}
+
+	tests := []struct {
+		prev    map[string]QosType
+		curr    map[string]QosType
+		added   int
+		removed int
+		changed int
+	}{
+		{
+			prev: map[string]QosType{"a": QoS0, "b": QoS1},
+			curr: map[string]QosType{"a": QoS0, "b": QoS1},
+		},
+		{
+			prev:  map[string]QosType{},
+			curr:  map[string]QosType{"a": QoS0, "b": QoS1},
+			added: 2,
+		},
+		{
+			prev:    map[string]QosType{"a": QoS0, "b": QoS1},
+			curr:    map[string]QosType{},
+			removed: 2,
+		},
+		{
+			prev:    map[string]QosType{"a": QoS0, "b": QoS1, "c": QoS2},
+			curr:    map[string]QosType{"a": QoS1, "c": QoS2, "d": QoS0},
+			added:   1,
+			removed: 1,
+			changed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		added, removed, changed := newMsg(tt.curr).ChurnStats(newMsg(tt.prev))
+		require.Equal(t, tt.added, added)
+		require.Equal(t, tt.removed, removed)
+		require.Equal(t, tt.changed, changed)
+	}
+
+	added, removed, changed := newMsg(map[string]QosType{"a": QoS0}).ChurnStats(nil)
+	require.Equal(t, 1, added)
+	require.Equal(t, 0, removed)
+	require.Equal(t, 0, changed)
+}
+
+// test same message content always produces same bytes
+func TestSubscribeEncodeGolden(t *testing.T) {
+	golden := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		23,
+		0,  // packet ID MSB (0)
+		10, // packet ID LSB (10)
+		0,  // topic name MSB (0)
+		12, // topic name LSB (12)
+		's', 'p', 'o', 'r', 't', '/', 't', 'e', 'n', 'n', 'i', 's',
+		1, // QoS
+		0, // topic name MSB (0)
+		3, // topic name LSB (3)
+		'a', '/', '#',
+		0, // QoS
+	}
+
+	for i := 0; i < 10; i++ {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(10)
+		require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+		require.NoError(t, msg.AddTopic("a/#", SubscriptionOptions(QoS0)))
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+		require.Equal(t, golden, buf)
+	}
+}
+
+func TestSubscribeWarnSharedOverlap(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("$share/g1/sport/golf", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.Nil(t, msg.WarnSharedOverlap())
+
+	require.NoError(t, msg.AddTopic("$share/g1/sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("$share/g2/sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("$share/g2/weather/#", SubscriptionOptions(QoS0)))
+	require.Equal(t, []string{"sport/tennis", "weather/#"}, msg.WarnSharedOverlap())
+}
+
+func TestSubscribeCoalesce(t *testing.T) {
+	var msgs []*Subscribe
+
+	topics := [][]string{
+		{"sport/tennis", "weather/#"},
+		{"news/+", "sport/tennis"},
+		{"weather/#", "sport/golf"},
+	}
+
+	for i, list := range topics {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		msg.SetPacketID(IDType(i + 1))
+
+		for _, topic := range list {
+			require.NoError(t, msg.AddTopic(topic, SubscriptionOptions(i)))
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	msg, err := CoalesceSubscribes(10, msgs)
+	require.NoError(t, err)
+
+	id, err := msg.ID()
+	require.NoError(t, err)
+	require.Equal(t, IDType(10), id)
+
+	require.Equal(t, []string{"sport/tennis", "weather/#", "news/+", "sport/golf"}, msg.topics)
+	require.Equal(t, []SubscriptionOptions{1, 2, 1, 2}, msg.ops)
+
+	_, err = CoalesceSubscribes(10, nil)
+	require.EqualError(t, err, ErrInvalidArgs.Error())
+}
+
+func TestSubscribeAnyMatch(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis/+", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("$share/g1/weather/#", SubscriptionOptions(QoS0)))
+
+	require.True(t, msg.AnyMatch("sport/tennis/player1"))
+	require.True(t, msg.AnyMatch("weather/london/today"))
+	require.False(t, msg.AnyMatch("sport/golf/player1"))
+	require.False(t, msg.AnyMatch("$SYS/weather"))
+}
+
+func BenchmarkSubscribeAnyMatch(b *testing.B) {
+	m, _ := New(ProtocolV311, SUBSCRIBE)
+	msg, _ := m.(*Subscribe)
+
+	for _, topic := range []string{"sport/tennis/+", "$share/g1/weather/#", "news/+/europe"} {
+		require.NoError(b, msg.AddTopic(topic, SubscriptionOptions(QoS1)))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		msg.AnyMatch("news/sport/europe")
+	}
+}
+
+func TestSubscribeShareAll(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("$share/old/weather/#", SubscriptionOptions(QoS0)))
+
+	require.EqualError(t, msg.ShareAll(""), ErrInvalidArgs.Error())
+	require.Equal(t, []string{"sport/tennis", "$share/old/weather/#"}, msg.topics)
+
+	require.NoError(t, msg.ShareAll("consumers"))
+	require.Equal(t, []string{"$share/consumers/sport/tennis", "$share/consumers/weather/#"}, msg.topics)
+	require.Equal(t, []SubscriptionOptions{SubscriptionOptions(QoS1), SubscriptionOptions(QoS0)}, msg.ops)
+}
+
+func TestSubscribeDecodeErrorOffset(t *testing.T) {
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		12,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', '/', 'b',
+		1, // QoS
+		0, // topic name MSB
+		1, // topic name LSB
+		'c',
+		3, // invalid QoS
+	}
+
+	_, _, err := DecodeWithOffset(ProtocolV311, msgBytes)
+	require.Error(t, err)
+
+	decodeErr, ok := err.(*DecodeError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, SUBSCRIBE, decodeErr.Type)
+
+	qosErr, ok := decodeErr.Err.(*QoSError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, CodeRefusedServerUnavailable, qosErr.Unwrap())
+	require.Equal(t, len(msgBytes)-1, decodeErr.Offset)
+	require.Equal(t, byte(3), msgBytes[decodeErr.Offset])
+}
+
+func TestSubscribeDecodeErrorOffsetTopic(t *testing.T) {
+	// invalid UTF-8 topic, offset points right after topic
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		7,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		2, // topic name LSB
+		0xC0, 0xAF,
+		1, // QoS
+	}
+
+	_, _, err := DecodeWithOffset(ProtocolV311, msgBytes)
+	require.Error(t, err)
+
+	decodeErr, ok := err.(*DecodeError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, CodeRefusedServerUnavailable, decodeErr.Err)
+	require.Equal(t, len(msgBytes)-1, decodeErr.Offset)
+
+	// no topics, offset points past packet ID
+	msgBytes = []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		2,
+		0, // packet ID MSB
+		7, // packet ID LSB
+	}
+
+	_, _, err = DecodeWithOffset(ProtocolV311, msgBytes)
+	require.Error(t, err)
+
+	decodeErr, ok = err.(*DecodeError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, CodeRefusedServerUnavailable, decodeErr.Err)
+	require.Equal(t, len(msgBytes), decodeErr.Offset)
+}
+
+func TestSubscribeDescribeSubscriptions(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.Equal(t, []string{}, msg.DescribeSubscriptions())
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(byte(QoS0)|maskSubscriptionNL)))
+	require.NoError(t, msg.AddTopic("$share/g1/news/+", SubscriptionOptions(QoS2)))
+
+	require.Equal(t, []string{
+		"sport/tennis (QoS 1)",
+		"weather/# (QoS 0)",
+		"$share/g1/news/+ (QoS 2)",
+	}, msg.DescribeSubscriptions())
+}
+
+func TestSubscribeHasSharedExclusiveConflict(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("$share/g1/sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+
+	filter, conflict := msg.HasSharedExclusiveConflict()
+	require.False(t, conflict)
+	require.Equal(t, "", filter)
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+
+	filter, conflict = msg.HasSharedExclusiveConflict()
+	require.True(t, conflict)
+	require.Equal(t, "sport/tennis", filter)
+}
+
+func TestSubscribeExpectedSubAckSize(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		for _, count := range []int{1, 3, 200} {
+			m, err := New(v, SUBSCRIBE)
+			require.NoError(t, err)
+
+			msg, ok := m.(*Subscribe)
+			require.True(t, ok, "Couldn't cast message type")
+
+			m, err = New(v, SUBACK)
+			require.NoError(t, err)
+
+			ack, ok := m.(*SubAck)
+			require.True(t, ok, "Couldn't cast message type")
+			ack.SetPacketID(1)
+
+			for i := 0; i < count; i++ {
+				require.NoError(t, msg.AddTopic("a/b", SubscriptionOptions(QoS1)))
+				require.NoError(t, ack.AddReturnCode(ReasonCode(QoS1)))
+			}
+
+			buf, err := Encode(ack)
+			require.NoError(t, err)
+			require.Equal(t, len(buf), msg.ExpectedSubAckSize(v))
+		}
+	}
+}
+
+func TestSubscribeSubscriptionIdentifier(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	_, ok = msg.SubscriptionIdentifier()
+	require.False(t, ok)
+
+	require.EqualError(t, msg.SetSubscriptionIdentifier(0), ErrInvalidArgs.Error())
+	require.EqualError(t, msg.SetSubscriptionIdentifier(268435456), ErrInvalidArgs.Error())
+
+	_, ok = msg.SubscriptionIdentifier()
+	require.False(t, ok)
+
+	for _, id := range []uint32{1, 268435455} {
+		require.NoError(t, msg.SetSubscriptionIdentifier(id))
+
+		v, ok := msg.SubscriptionIdentifier()
+		require.True(t, ok)
+		require.Equal(t, id, v)
+	}
+}
+
+func TestSubscribeDowngradeUnsupportedQoS(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(byte(QoS2)|maskSubscriptionNL)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS1)))
+
+	require.False(t, msg.DowngradeUnsupportedQoS(QoS2))
+
+	require.True(t, msg.DowngradeUnsupportedQoS(QoS1))
+	require.Equal(t, QoS1, msg.ops[0].QoS())
+	require.True(t, msg.ops[0].NL())
+	require.Equal(t, QoS1, msg.ops[1].QoS())
+
+	require.False(t, msg.DowngradeUnsupportedQoS(QoS1))
+}
+
+// Encode writes straight into caller buffer, there is no internal cache to disable
+func BenchmarkSubscribeEncode(b *testing.B) {
+	m, _ := New(ProtocolV311, SUBSCRIBE)
+	msg, _ := m.(*Subscribe)
+	msg.SetPacketID(1)
+
+	for _, topic := range []string{"sport/tennis/+", "weather/#", "news/+/europe"} {
+		require.NoError(b, msg.AddTopic(topic, SubscriptionOptions(QoS1)))
+	}
+
+	buf := make([]byte, 128)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.Encode(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSubscribeFreeze(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	_, ok = msg.Freeze().PacketID()
+	require.False(t, ok)
+
+	msg.SetPacketID(7)
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+
+	frozen := msg.Freeze()
+
+	msg.SetPacketID(8)
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	msg.topics[0] = "sport/golf"
+	msg.ops[0] = SubscriptionOptions(QoS2)
+
+	id, ok := frozen.PacketID()
+	require.True(t, ok)
+	require.Equal(t, IDType(7), id)
+
+	topics := frozen.Topics()
+	require.Equal(t, []string{"sport/tennis"}, topics)
+	require.Equal(t, []SubscriptionOptions{SubscriptionOptions(QoS1)}, frozen.Options())
+
+	topics[0] = "changed"
+	require.Equal(t, []string{"sport/tennis"}, frozen.Topics())
+}
+
+func TestSubscribeDistinctLevels(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	require.Equal(t, 0, msg.DistinctLevels())
+
+	require.NoError(t, msg.AddTopic("sport/tennis/player1", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("sport/tennis/player2", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("sport/golf/#", SubscriptionOptions(QoS0)))
+	require.NoError(t, msg.AddTopic("news/+/tennis", SubscriptionOptions(QoS0)))
+
+	// sport tennis player1 player2 golf # news +
+	require.Equal(t, 8, msg.DistinctLevels())
+}
+
+func TestSubscribeSharedNoLocal(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	nl := SubscriptionOptions(byte(QoS1) | maskSubscriptionNL)
+
+	require.NoError(t, msg.AddTopic("sport/tennis", nl))
+	require.EqualError(t, msg.AddTopic("$share/g1/sport/tennis", nl), ErrProtocolViolation.Error())
+	require.NoError(t, msg.AddTopic("$share/g1/sport/tennis", SubscriptionOptions(QoS1)))
+
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		16,
+		0,  // packet ID MSB
+		7,  // packet ID LSB
+		0,  // no properties
+		0,  // topic name MSB
+		10, // topic name LSB
+		'$', 's', 'h', 'a', 'r', 'e', '/', 'g', '/', 'a',
+		byte(QoS1) | maskSubscriptionNL,
+	}
+
+	_, _, err = Decode(ProtocolV50, msgBytes)
+	require.EqualError(t, err, CodeProtocolError.Error())
+}
+
+func TestSubscribeShareAllNoLocal(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(byte(QoS1)|maskSubscriptionNL)))
+	require.EqualError(t, msg.ShareAll("g1"), ErrProtocolViolation.Error())
+	require.Equal(t, []string{"sport/tennis"}, msg.topics)
+}
+
+func TestSubscribeSubscriptionKeys(t *testing.T) {
+	for v, expected := range map[ProtocolVersion][]string{
+		ProtocolV311: {"sport/tennis|q1", "weather/#|q0"},
+		ProtocolV50:  {"sport/tennis|q1|nl1|rap0|rh2", "weather/#|q0|nl0|rap1|rh0"},
+	} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		msg.SetPacketID(1)
+
+		first, second := SubscriptionOptions(QoS1), SubscriptionOptions(QoS0)
+		if v == ProtocolV50 {
+			first |= SubscriptionOptions(maskSubscriptionNL | 2<<4)
+			second |= SubscriptionOptions(maskSubscriptionRAP)
+		}
+
+		require.NoError(t, msg.AddTopic("sport/tennis", first))
+		require.NoError(t, msg.AddTopic("weather/#", second))
+		require.Equal(t, expected, msg.SubscriptionKeys())
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+
+		m, _, err = Decode(v, buf)
+		require.NoError(t, err)
+
+		decoded, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		require.Equal(t, expected, decoded.SubscriptionKeys())
+	}
+}
+
+func TestSubscribeRemoveTopicShrink(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, msg.AddTopic("a/"+strconv.Itoa(i), SubscriptionOptions(QoS1)))
+	}
+
+	for i := 0; i < 98; i++ {
+		require.True(t, msg.RemoveTopic("a/"+strconv.Itoa(i)))
+	}
+
+	require.False(t, msg.RemoveTopic("a/0"))
+	require.Equal(t, []string{"a/98", "a/99"}, msg.topics)
+	require.True(t, cap(msg.topics) > len(msg.topics))
+
+	msg.Shrink()
+
+	require.Equal(t, []string{"a/98", "a/99"}, msg.topics)
+	require.Equal(t, len(msg.topics), cap(msg.topics))
+	require.Equal(t, len(msg.ops), cap(msg.ops))
+	require.Equal(t, 2, len(msg.ops))
+}
+
+func TestSubscribeBuildExactSet(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("sport/+", SubscriptionOptions(QoS0)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.NoError(t, msg.AddTopic("$share/g1/news/today", SubscriptionOptions(QoS2)))
+
+	require.Equal(t, map[string]QosType{"sport/tennis": QoS1, "news/today": QoS2}, msg.BuildExactSet())
+}
+
+func newBenchExactSubscribe(b *testing.B) *Subscribe {
+	m, _ := New(ProtocolV311, SUBSCRIBE)
+	msg, _ := m.(*Subscribe)
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(b, msg.AddTopic("devices/"+strconv.Itoa(i)+"/state", SubscriptionOptions(QoS1)))
+	}
+
+	return msg
+}
+
+func BenchmarkSubscribeExactSetLookup(b *testing.B) {
+	set := newBenchExactSubscribe(b).BuildExactSet()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok := set["devices/999/state"]; !ok {
+			b.Fatal("topic not found")
+		}
+	}
+}
+
+func BenchmarkSubscribeExactLinearScan(b *testing.B) {
+	msg := newBenchExactSubscribe(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !msg.AnyMatch("devices/999/state") {
+			b.Fatal("topic not found")
+		}
+	}
+}
+
+func TestSubscribePropertiesLengthBoundaries(t *testing.T) {
+	for pLen, prefix := range map[int][]byte{
+		127:   {0x7F},
+		128:   {0x80, 0x01},
+		16383: {0xFF, 0x7F},
+		16384: {0x80, 0x80, 0x01},
+	} {
+		m, err := New(ProtocolV50, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		msg.SetPacketID(1)
+		require.NoError(t, msg.AddTopic("a/b", SubscriptionOptions(QoS1)))
+
+		// property id + key and value length prefixes + key
+		value := strings.Repeat("v", pLen-6)
+		require.NoError(t, msg.PropertySet(PropertyUserProperty, []StringPair{{K: "k", V: value}}))
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+
+		// fixed header + packet ID
+		_, remLenSize := uvarint(buf[1:])
+		offset := 1 + remLenSize + 2
+		require.Equal(t, prefix, buf[offset:offset+len(prefix)], pLen)
+
+		m, n, err := Decode(ProtocolV50, buf)
+		require.NoError(t, err)
+		require.Equal(t, len(buf), n)
+
+		decoded, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+		require.Equal(t, []string{"a/b"}, decoded.topics)
+
+		prop := decoded.PropertyGet(PropertyUserProperty)
+		require.NotNil(t, prop)
+
+		pairs, err := prop.AsStringPairs()
+		require.NoError(t, err)
+		require.Equal(t, []StringPair{{K: "k", V: value}}, pairs)
+	}
+}
+
+func TestSubscribeHasCatchAll(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("a/#", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("+", SubscriptionOptions(QoS1)))
+	require.False(t, msg.HasCatchAll())
+
+	require.NoError(t, msg.AddTopic("#", SubscriptionOptions(QoS0)))
+	require.True(t, msg.HasCatchAll())
+}
+
+func TestSubscribeSplitByQoS(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	msg.SetPacketID(1)
+	require.NoError(t, msg.SetSubscriptionIdentifier(3))
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.NoError(t, msg.AddTopic("news/+", SubscriptionOptions(QoS1)))
+
+	id := IDType(10)
+	parts := msg.SplitByQoS(func() IDType {
+		id++
+		return id
+	})
+
+	require.Equal(t, 2, len(parts))
+	require.Nil(t, parts[QoS2])
+
+	require.Equal(t, []string{"sport/tennis", "news/+"}, parts[QoS1].topics)
+	require.Equal(t, []string{"weather/#"}, parts[QoS0].topics)
+
+	id1, err := parts[QoS1].ID()
+	require.NoError(t, err)
+	id0, err := parts[QoS0].ID()
+	require.NoError(t, err)
+	require.Equal(t, IDType(11), id1)
+	require.Equal(t, IDType(12), id0)
+
+	for _, p := range parts {
+		subID, ok := p.SubscriptionIdentifier()
+		require.True(t, ok)
+		require.Equal(t, uint32(3), subID)
+
+		_, err = Encode(p)
+		require.NoError(t, err)
+	}
+}
+
+func TestSubscribeDecodeTruncated(t *testing.T) {
+	full := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		16,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', '/', 'b',
+		1, // QoS
+		0, // topic name MSB
+		5, // topic name LSB
+		'c', '/', 'd', '/', 'e',
+		0, // QoS
+	}
+
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		for remLen := 0; remLen < len(full)-2; remLen++ {
+			buf := make([]byte, remLen+2)
+			copy(buf, full)
+			buf[1] = byte(remLen)
+
+			if v == ProtocolV50 && remLen > 2 {
+				// insert empty properties after packet ID
+				buf = append(buf[:4], append([]byte{0}, buf[4:]...)...)
+				buf[1]++
+			}
+
+			_, _, err := Decode(v, buf)
+
+			if remLen == 8 {
+				// first subscription is complete
+				require.NoError(t, err, remLen)
+				continue
+			}
+
+			require.Error(t, err, remLen)
+			require.NotEqual(t, ErrPanicDetected, err, remLen)
+		}
+	}
+}
+
+func TestSubscribeCheckRemainingLength(t *testing.T) {
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		8,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', '/', 'b',
+		1, // QoS
+	}
+
+	m, _, err := Decode(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	require.NoError(t, msg.CheckRemainingLength())
+
+	require.NoError(t, SetMaxRemainingLength(SUBSCRIBE, 10))
+	defer SetMaxRemainingLength(SUBSCRIBE, 0) // nolint: errcheck
+
+	// stale decoded remaining length is not trusted
+	msg.remLen = 100
+	require.NoError(t, msg.CheckRemainingLength())
+
+	// modified after decode to exceed limit
+	require.NoError(t, msg.AddTopic("c", SubscriptionOptions(QoS0)))
+	require.Equal(t, int32(12), msg.RemainingLength())
+	require.EqualError(t, msg.CheckRemainingLength(), ErrInvalidLength.Error())
+
+	// built from scratch
+	m, err = New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	built, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	built.SetPacketID(8)
+	require.NoError(t, built.AddTopic("a/b", SubscriptionOptions(QoS1)))
+	require.NoError(t, built.CheckRemainingLength())
+
+	require.NoError(t, built.AddTopic("c/d", SubscriptionOptions(QoS1)))
+	require.EqualError(t, built.CheckRemainingLength(), ErrInvalidLength.Error())
+}
+
+func TestSubscribeDecodeInvalidQoS(t *testing.T) {
+	for v, expected := range map[ProtocolVersion]ReasonCode{
+		ProtocolV311: CodeRefusedServerUnavailable,
+		ProtocolV50:  CodeMalformedPacket,
+	} {
+		msgBytes := []byte{
+			byte(SUBSCRIBE<<4) | 2,
+			8,
+			0, // packet ID MSB
+			7, // packet ID LSB
+			0, // topic name MSB
+			3, // topic name LSB
+			'a', '/', 'b',
+			3, // QoS
+		}
+
+		if v == ProtocolV50 {
+			// empty properties
+			msgBytes = append(msgBytes[:4], append([]byte{0}, msgBytes[4:]...)...)
+			msgBytes[1]++
+		}
+
+		_, _, err := DecodeWithOffset(v, msgBytes)
+		require.Error(t, err)
+
+		decodeErr, ok := err.(*DecodeError)
+		require.True(t, ok, "Invalid error type")
+
+		qosErr, ok := decodeErr.Err.(*QoSError)
+		require.True(t, ok, "Invalid error type")
+		require.Equal(t, QosType(3), qosErr.QoS)
+		require.Equal(t, "a/b", qosErr.Topic)
+		require.Equal(t, expected, qosErr.Err)
+		require.EqualError(t, qosErr, `invalid requested QoS 3 for topic "a/b"`)
+		require.Contains(t, err.Error(), `invalid requested QoS 3 for topic "a/b"`)
+		require.Equal(t, expected, qosErr.Unwrap())
+
+		// offending topic precedes QoS byte
+		require.Equal(t, len(msgBytes)-1, decodeErr.Offset)
+		require.Equal(t, []byte("a/b"), msgBytes[decodeErr.Offset-3:decodeErr.Offset])
+	}
+}
+
+func TestSubscribeDecodeDoesNotAliasSource(t *testing.T) {
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		8,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', '/', 'b',
+		1, // QoS
+	}
+
+	m, _, err := Decode(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	// emulate reuse of pooled buffer
+	for i := range msgBytes {
+		msgBytes[i] = 'x'
+	}
+
+	require.Equal(t, []string{"a/b"}, msg.Topics())
+}
+
+func TestSubscribeFan(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	msg.SetPacketID(42)
+
+	require.NoError(t, msg.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, msg.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.NoError(t, msg.AddTopic("news/+", SubscriptionOptions(QoS2)))
+
+	require.Equal(t, []*SingleSubscribe{
+		{Topic: "sport/tennis", Options: SubscriptionOptions(QoS1), PacketID: 42, Index: 0},
+		{Topic: "weather/#", Options: SubscriptionOptions(QoS0), PacketID: 42, Index: 1},
+		{Topic: "news/+", Options: SubscriptionOptions(QoS2), PacketID: 42, Index: 2},
+	}, msg.Fan())
+}
+
+func TestSubscribeAddTopicEncoding(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	for _, topic := range []string{
+		"a\xc0\xafb",    // overlong encoding of '/'
+		"a\xe0\x80\xaf", // overlong 3-byte encoding
+		"a/\x00/b",      // embedded null
+		"\xff",
+	} {
+		require.EqualError(t, msg.AddTopic(topic, SubscriptionOptions(QoS1)), ErrMalformedTopic.Error(), topic)
+	}
+
+	require.NoError(t, msg.AddTopic("a/ü/b", SubscriptionOptions(QoS1)))
+
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		8,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		3, // topic name LSB
+		'a', 0, 'b',
+		1, // QoS
+	}
+
+	_, _, err = Decode(ProtocolV311, msgBytes)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+}
+
+func TestSubscribeAddTopicWildcards(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	for _, topic := range []string{"a/+/b", "a/#", "#", "+", "+/+", "$share/g/a/+/#"} {
+		require.NoError(t, msg.AddTopic(topic, SubscriptionOptions(QoS1)), topic)
+	}
+
+	invalid := map[string]error{
+		"a/#/b":          ErrMisplacedMultiLevelWildcard,
+		"a#":             ErrMisplacedMultiLevelWildcard,
+		"a+":             ErrMisplacedSingleLevelWildcard,
+		"a/b+c":          ErrMisplacedSingleLevelWildcard,
+		"$share/g/a/#/b": ErrMisplacedMultiLevelWildcard,
+		"$share/g/a+":    ErrMisplacedSingleLevelWildcard,
+		"":               ErrInvalidTopic,
+	}
+
+	for topic, e := range invalid {
+		require.Equal(t, e, msg.AddTopic(topic, SubscriptionOptions(QoS1)), topic)
+	}
+
+	require.Len(t, msg.Topics(), 6)
+}
+
+func TestSubscribeV5OptionsRoundTrip(t *testing.T) {
+	for q := QoS0; q <= QoS2; q++ {
+		for _, nl := range []bool{false, true} {
+			for _, rap := range []bool{false, true} {
+				for rh := RetainHandlingRetain; rh <= RetainHandlingDoNotRetain; rh++ {
+					ops := NewSubscriptionOptions(q, nl, rap, rh)
+					require.Equal(t, q, ops.QoS())
+					require.Equal(t, nl, ops.NL())
+					require.Equal(t, rap, ops.RAP())
+					require.Equal(t, rh, ops.RetainHandling())
+
+					m, err := New(ProtocolV50, SUBSCRIBE)
+					require.NoError(t, err)
+
+					msg, ok := m.(*Subscribe)
+					require.True(t, ok, "Couldn't cast message type")
+
+					msg.SetPacketID(1)
+					require.NoError(t, msg.AddTopic("a/b", ops))
+
+					buf := make([]byte, 32)
+					n, err := msg.Encode(buf)
+					require.NoError(t, err)
+
+					m1, _, err := Decode(ProtocolV50, buf[:n])
+					require.NoError(t, err)
+
+					msg1, ok := m1.(*Subscribe)
+					require.True(t, ok, "Couldn't cast message type")
+
+					msg1.RangeTopics(func(topic string, o SubscriptionOptions) {
+						require.Equal(t, "a/b", topic)
+						require.Equal(t, ops, o)
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestSubscribeV5RetainHandlingReserved(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.EqualError(t, msg.AddTopic("a", SubscriptionOptions(0x30)), ErrInvalidArgs.Error())
+
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		7,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // properties length
+		0, // topic name MSB
+		1, // topic name LSB
+		'a',
+		0x31, // retain handling 3
+	}
+
+	_, _, err = Decode(ProtocolV50, msgBytes)
+	require.EqualError(t, err, CodeProtocolError.Error())
+}
+
+func TestSubscribeSubscriptionIdentifierWire(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(7)
+	require.NoError(t, msg.SetSubscriptionIdentifier(200))
+	require.NoError(t, msg.AddTopic("a", SubscriptionOptions(QoS1)))
+
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		10,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		3, // properties length
+		byte(PropertySubscriptionIdentifier),
+		0xC8, 0x01, // 200 as variable byte integer
+		0, // topic name MSB
+		1, // topic name LSB
+		'a',
+		1, // QoS
+	}
+
+	size, err := msg.Size()
+	require.NoError(t, err)
+	require.Equal(t, len(msgBytes), size)
+
+	dst := make([]byte, size)
+	_, err = msg.Encode(dst)
+	require.NoError(t, err)
+	require.Equal(t, msgBytes, dst)
+
+	m1, _, err := Decode(ProtocolV50, msgBytes)
+	require.NoError(t, err)
+
+	id, ok := m1.(*Subscribe).SubscriptionIdentifier()
+	require.True(t, ok)
+	require.Equal(t, uint32(200), id)
+
+	zeroID := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		9,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		2, // properties length
+		byte(PropertySubscriptionIdentifier),
+		0, // identifier 0
+		0, // topic name MSB
+		1, // topic name LSB
+		'a',
+		1, // QoS
+	}
+
+	_, _, err = Decode(ProtocolV50, zeroID)
+	require.EqualError(t, err, CodeProtocolError.Error())
+}
+
+func TestSubscribeUserProperties(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopic("a/b", SubscriptionOptions(QoS1)))
+
+	expected := []StringPair{
+		{K: "region", V: "eu"},
+		{K: "tag", V: "one"},
+		{K: "region", V: "us"},
+		{K: "tag", V: ""},
+	}
+
+	for _, p := range expected {
+		require.NoError(t, msg.AddUserProperty(p.K, p.V))
+	}
+
+	require.EqualError(t, msg.AddUserProperty("a\x00", "b"), ErrInvalidArgs.Error())
+	require.Equal(t, expected, msg.UserProperties())
+
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+
+	// packet ID, properties length, 4 pairs, topic and options
+	require.Equal(t, byte(2+1+(1+2+6+2+2)+(1+2+3+2+3)+(1+2+6+2+2)+(1+2+3+2)+2+3+1), buf[1])
+
+	m1, _, err := Decode(ProtocolV50, buf)
+	require.NoError(t, err)
+	require.Equal(t, expected, m1.(*Subscribe).UserProperties())
+
+	buf1, err := Encode(m1)
+	require.NoError(t, err)
+	require.Equal(t, buf, buf1)
+
+	m, err = New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+	require.EqualError(t, m.(*Subscribe).AddUserProperty("k", "v"), ErrNotSupported.Error())
+}
+
+func TestSubscribeAddTopics(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopic("a", SubscriptionOptions(QoS0)))
+
+	require.EqualError(t, msg.AddTopics([]string{"b"}, nil), ErrInvalidArgs.Error())
+
+	err = msg.AddTopics([]string{"b", "c/#/d", "e"}, []SubscriptionOptions{1, 1, 1})
+	require.Error(t, err)
+
+	subErr, ok := err.(*SubscriptionError)
+	require.True(t, ok)
+	require.Equal(t, 1, subErr.Index)
+	require.Equal(t, "c/#/d", subErr.Topic)
+	require.Equal(t, ErrMisplacedMultiLevelWildcard, subErr.Unwrap())
+	require.Equal(t, []string{"a"}, msg.Topics())
+
+	err = msg.AddTopics([]string{"b", "c"}, []SubscriptionOptions{1, 3})
+	require.EqualError(t, err, "subscription 1 (c): "+ErrInvalidQoS.Error())
+
+	// repeated topics are replaced, last options win
+	require.NoError(t, msg.AddTopics([]string{"b", "c", "b"}, []SubscriptionOptions{1, 2, 0}))
+	require.Equal(t, []string{"a", "b", "c"}, msg.Topics())
+	require.Equal(t, []SubscriptionOptions{0, 0, 2}, msg.Freeze().Options())
+
+	require.NoError(t, msg.AddTopics([]string{"c", "a", "d"}, []SubscriptionOptions{1, 2, 1}))
+	require.Equal(t, []string{"a", "b", "c", "d"}, msg.Topics())
+	require.Equal(t, []SubscriptionOptions{2, 0, 1, 1}, msg.Freeze().Options())
+}
+
+func benchmarkTopics(n int) ([]string, []SubscriptionOptions) {
+	topics := make([]string, n)
+	ops := make([]SubscriptionOptions, n)
+
+	for i := range topics {
+		topics[i] = "sensors/" + strconv.Itoa(i) + "/+"
+		ops[i] = SubscriptionOptions(QoS1)
+	}
+
+	return topics, ops
+}
+
+func BenchmarkSubscribeAddTopic1000(b *testing.B) {
+	topics, ops := benchmarkTopics(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		msg := newSubscribe()
+		msg.version = ProtocolV311
+
+		for j, t := range topics {
+			msg.AddTopic(t, ops[j]) // nolint: errcheck
+		}
+	}
+}
+
+func BenchmarkSubscribeAddTopics1000(b *testing.B) {
+	topics, ops := benchmarkTopics(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		msg := newSubscribe()
+		msg.version = ProtocolV311
+
+		msg.AddTopics(topics, ops) // nolint: errcheck
+	}
+}
+
+func TestSubscribeIdempotencyKey(t *testing.T) {
+	build := func(v ProtocolVersion, id IDType, topics []string, ops []SubscriptionOptions) *Subscribe {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(id)
+		require.NoError(t, msg.AddTopics(topics, ops))
+
+		return msg
+	}
+
+	a := build(ProtocolV311, 1, []string{"a/b", "c/#"}, []SubscriptionOptions{1, 2})
+	b := build(ProtocolV311, 2, []string{"c/#", "a/b"}, []SubscriptionOptions{2, 1})
+	c := build(ProtocolV311, 1, []string{"a/b", "c/#"}, []SubscriptionOptions{1, 1})
+
+	require.Len(t, a.IdempotencyKey(), 64)
+	require.Equal(t, a.IdempotencyKey(), b.IdempotencyKey())
+	require.NotEqual(t, a.IdempotencyKey(), c.IdempotencyKey())
+
+	v5a := build(ProtocolV50, 1, []string{"a/b"}, []SubscriptionOptions{NewSubscriptionOptions(QoS1, false, false, RetainHandlingRetain)})
+	v5b := build(ProtocolV50, 1, []string{"a/b"}, []SubscriptionOptions{NewSubscriptionOptions(QoS1, false, true, RetainHandlingRetain)})
+	require.NotEqual(t, v5a.IdempotencyKey(), v5b.IdempotencyKey())
+}
+
+func TestSubscribeConcurrentAddTopicEncode(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopic("a", SubscriptionOptions(QoS0)))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 500; i++ {
+			msg.AddTopic("sensors/"+strconv.Itoa(i), SubscriptionOptions(QoS1)) // nolint: errcheck
+			if i%10 == 0 {
+				msg.RemoveTopic("sensors/" + strconv.Itoa(i-5))
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		buf := make([]byte, 16*1024)
+
+		for i := 0; i < 500; i++ {
+			n, err := msg.Encode(buf)
+			require.NoError(t, err)
+
+			_, _, err = Decode(ProtocolV311, buf[:n])
+			require.NoError(t, err)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestSubscribeClone(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(11)
+	require.NoError(t, msg.SetSubscriptionIdentifier(7))
+	require.NoError(t, msg.AddUserProperty("k", "v"))
+	require.NoError(t, msg.AddTopic("a/b", NewSubscriptionOptions(QoS1, true, false, RetainHandlingRetain)))
+	require.NoError(t, msg.AddTopic("c/#", SubscriptionOptions(QoS2)))
+
+	expected, err := Encode(msg)
+	require.NoError(t, err)
+
+	c := msg.Clone()
+
+	require.True(t, msg.RemoveTopic("a/b"))
+	require.NoError(t, msg.AddTopic("d", SubscriptionOptions(QoS0)))
+	require.True(t, msg.DowngradeUnsupportedQoS(QoS0))
+	msg.SetPacketID(12)
+	require.NoError(t, msg.SetSubscriptionIdentifier(8))
+	require.NoError(t, msg.AddUserProperty("k2", "v2"))
+
+	require.Equal(t, []string{"a/b", "c/#"}, c.Topics())
+
+	id, err := c.ID()
+	require.NoError(t, err)
+	require.Equal(t, IDType(11), id)
+
+	subID, _ := c.SubscriptionIdentifier()
+	require.Equal(t, uint32(7), subID)
+	require.Equal(t, []StringPair{{K: "k", V: "v"}}, c.UserProperties())
+
+	actual, err := Encode(c)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+}
+
+func TestSubscribeStats(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopics([]string{"a", "b", "c"}, []SubscriptionOptions{0, 1, 2}))
+
+	require.True(t, msg.IncDelivered("b"))
+	require.True(t, msg.IncDelivered("c"))
+	require.True(t, msg.IncDelivered("c"))
+	require.False(t, msg.IncDelivered("x"))
+
+	// topic added after stats allocated
+	require.NoError(t, msg.AddTopic("d", SubscriptionOptions(QoS1)))
+
+	st, ok := msg.Stats("d")
+	require.True(t, ok)
+	require.Equal(t, uint64(0), st.Delivered)
+
+	require.True(t, msg.RemoveTopic("a"))
+
+	st, _ = msg.Stats("b")
+	require.Equal(t, uint64(1), st.Delivered)
+	st, _ = msg.Stats("c")
+	require.Equal(t, uint64(2), st.Delivered)
+
+	require.True(t, msg.RemoveTopic("b"))
+	msg.Shrink()
+
+	require.True(t, msg.IncDelivered("d"))
+
+	st, _ = msg.Stats("c")
+	require.Equal(t, uint64(2), st.Delivered)
+	st, _ = msg.Stats("d")
+	require.Equal(t, uint64(1), st.Delivered)
+
+	_, ok = msg.Stats("a")
+	require.False(t, ok)
+
+	// stats are not part of the wire format
+	buf, err := Encode(msg)
+	require.NoError(t, err)
+
+	m1, _, err := Decode(ProtocolV311, buf)
+	require.NoError(t, err)
+
+	st, ok = m1.(*Subscribe).Stats("c")
+	require.True(t, ok)
+	require.Equal(t, uint64(0), st.Delivered)
+}
+
+func TestSubscribeEqual(t *testing.T) {
+	build := func(id IDType, topics []string, ops []SubscriptionOptions) *Subscribe {
+		m, err := New(ProtocolV311, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(id)
+		require.NoError(t, msg.AddTopics(topics, ops))
+
+		return msg
+	}
+
+	a := build(1, []string{"a", "b"}, []SubscriptionOptions{1, 2})
+
+	require.True(t, a.Equal(a))
+	require.False(t, a.Equal(nil))
+
+	b := build(1, []string{"a", "b"}, []SubscriptionOptions{1, 2})
+	require.True(t, a.IncDelivered("a"))
+	require.True(t, a.Equal(b))
+	require.True(t, b.Equal(a))
+
+	require.False(t, a.Equal(build(1, []string{"b", "a"}, []SubscriptionOptions{2, 1})))
+	require.False(t, a.Equal(build(1, []string{"a", "b"}, []SubscriptionOptions{1, 1})))
+	require.False(t, a.Equal(build(2, []string{"a", "b"}, []SubscriptionOptions{1, 2})))
+	require.False(t, a.Equal(build(1, []string{"a"}, []SubscriptionOptions{1})))
+
+	buf, err := Encode(a)
+	require.NoError(t, err)
+
+	m, _, err := Decode(ProtocolV311, buf)
+	require.NoError(t, err)
+	require.True(t, a.Equal(m.(*Subscribe)))
+}
+
+func TestSubscribeBinaryMarshaler(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(3)
+		require.NoError(t, msg.AddTopics([]string{"a/+", "b/#"}, []SubscriptionOptions{1, 2}))
+
+		var marshaler encoding.BinaryMarshaler = msg
+		data, err := marshaler.MarshalBinary()
+		require.NoError(t, err)
+
+		expected, err := Encode(msg)
+		require.NoError(t, err)
+		require.Equal(t, expected, data)
+
+		// modifications after marshal are reflected by next marshal
+		require.NoError(t, msg.AddTopic("c", SubscriptionOptions(QoS0)))
+		data1, err := marshaler.MarshalBinary()
+		require.NoError(t, err)
+		require.NotEqual(t, data, data1)
+
+		m1, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		restored := m1.(*Subscribe)
+		require.NoError(t, restored.AddTopic("stale", SubscriptionOptions(QoS0)))
+
+		var unmarshaler encoding.BinaryUnmarshaler = restored
+		require.NoError(t, unmarshaler.UnmarshalBinary(data1))
+		require.True(t, msg.Equal(restored))
+
+		reencoded, err := restored.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, data1, reencoded)
+
+		require.EqualError(t, restored.UnmarshalBinary(append(data1, 0)), ErrInvalidLength.Error())
+	}
+
+	require.EqualError(t, (&Subscribe{}).UnmarshalBinary([]byte{}), ErrInvalidProtocolVersion.Error())
+}
+
+func TestSubscribeRangeReverse(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopics([]string{"a", "b", "c"}, []SubscriptionOptions{0, 1, 2}))
+
+	var topics []string
+	var ops []SubscriptionOptions
+
+	msg.RangeReverse(func(topic string, o SubscriptionOptions) bool {
+		topics = append(topics, topic)
+		ops = append(ops, o)
+		return true
+	})
+
+	require.Equal(t, []string{"c", "b", "a"}, topics)
+	require.Equal(t, []SubscriptionOptions{2, 1, 0}, ops)
+
+	topics = topics[:0]
+
+	msg.RangeReverse(func(topic string, o SubscriptionOptions) bool {
+		topics = append(topics, topic)
+		return topic != "b"
+	})
+
+	require.Equal(t, []string{"c", "b"}, topics)
+}
+
+func TestSubscribeRangeModify(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopics([]string{"a", "b", "c"}, []SubscriptionOptions{0, 1, 2}))
+
+	var topics []string
+
+	msg.RangeTopics(func(topic string, o SubscriptionOptions) {
+		topics = append(topics, topic)
+		if topic == "a" {
+			require.True(t, msg.RemoveTopic("a"))
+		}
+	})
+
+	require.Equal(t, []string{"a", "b", "c"}, topics)
+	require.Equal(t, []string{"b", "c"}, msg.Topics())
+
+	topics = topics[:0]
+
+	msg.RangeReverse(func(topic string, o SubscriptionOptions) bool {
+		topics = append(topics, topic)
+		if topic == "c" {
+			require.True(t, msg.RemoveTopic("b"))
+		}
+		return true
+	})
+
+	require.Equal(t, []string{"c", "b"}, topics)
+	require.Equal(t, []string{"c"}, msg.Topics())
+}
+
+func TestSubscribeConcurrentProperties(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopic("a/+", 1))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 1; i <= 200; i++ {
+			msg.AddUserProperty("k", strconv.Itoa(i))          // nolint: errcheck
+			msg.SetSubscriptionIdentifier(uint32(i))           // nolint: errcheck
+			msg.PropertySet(PropertySubscriptionIdentifier, 1) // nolint: errcheck
+			msg.AddTopic("b/"+strconv.Itoa(i), 0)              // nolint: errcheck
+			msg.SetPacketID(IDType(i))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 200; i++ {
+			msg.UserProperties()
+			msg.SubscriptionIdentifier()
+			msg.CheckSubscriptionIDAllowed(false) // nolint: errcheck
+			msg.PropertyGet(PropertyUserProperty)
+			msg.MinProtocolVersion()
+			msg.IdempotencyKey()
+			msg.Fan()
+			msg.SplitByQoS(func() IDType { return 1 })
+			msg.ChurnStats(msg)
+			msg.HasSharedExclusiveConflict()
+			Trace(msg)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestSubscribeConcurrentCoalesceHeader(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.AddTopic("a/+", 1))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 200; i++ {
+			msg.AddTopic("b/"+strconv.Itoa(i), 0) // nolint: errcheck
+
+			if i%50 == 0 {
+				msg.Reset()
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		hdr := make([]byte, 5)
+
+		for i := 0; i < 200; i++ {
+			CoalesceSubscribes(2, []*Subscribe{msg, msg}) // nolint: errcheck
+			msg.RemainingLength()
+			msg.CheckRemainingLength() // nolint: errcheck
+			msg.EncodeHeader(hdr, 10)  // nolint: errcheck
+			msg.Flags()
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestSubscribeConcurrentEqual(t *testing.T) {
+	subs := make([]*Subscribe, 2)
+	for i := range subs {
+		m, err := New(ProtocolV50, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(1)
+		require.NoError(t, msg.AddTopic("a/+", 1))
+		subs[i] = msg
+	}
+
+	a, b := subs[0], subs[1]
+	require.True(t, a.Equal(b))
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// opposite comparison order must not deadlock while writers wait for lock
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 500; i++ {
+			a.Equal(b)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 500; i++ {
+			b.Equal(a)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 1; i <= 500; i++ {
+			a.SetPacketID(IDType(i))
+			b.SetPacketID(IDType(i))
+		}
+	}()
+
+	wg.Wait()
+
+	require.True(t, a.Equal(b))
+}
+
+func TestSubscribeConcurrentRangeRemove(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, msg.AddTopic("sensors/"+strconv.Itoa(i), 1))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			msg.RemoveTopic("sensors/" + strconv.Itoa(i))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			msg.RangeTopics(func(topic string, o SubscriptionOptions) {
+				require.True(t, strings.HasPrefix(topic, "sensors/"))
+			})
+		}
+	}()
+
+	wg.Wait()
+}
+
+var pooledSubscribeBytes = []byte{
+	byte(SUBSCRIBE<<4) | 2,
+	14,
+	0, // packet ID MSB
+	7, // packet ID LSB
+	0, // topic name MSB
+	3, // topic name LSB
+	'a', '/', 'b',
+	1, // QoS
+	0, // topic name MSB
+	3, // topic name LSB
+	'c', '/', '#',
+	2, // QoS
+}
+
+func TestSubscribeReset(t *testing.T) {
+	msg, err := GetSubscribe(ProtocolV50)
+	require.NoError(t, err)
+
+	msg.SetPacketID(5)
+	require.NoError(t, msg.SetSubscriptionIdentifier(3))
+	require.NoError(t, msg.AddTopic("x", SubscriptionOptions(QoS1)))
+	require.True(t, msg.IncDelivered("x"))
+
+	msg.Reset()
+
+	_, err = msg.ID()
+	require.Error(t, err)
+	require.Equal(t, 0, msg.TopicCount())
+	_, ok := msg.SubscriptionIdentifier()
+	require.False(t, ok)
+	require.Equal(t, ProtocolV50, msg.Version())
+
+	PutSubscribe(msg)
+
+	msg, err = GetSubscribe(ProtocolV311)
+	require.NoError(t, err)
+	require.Equal(t, ProtocolV311, msg.Version())
+
+	for i := 0; i < 3; i++ {
+		n, err := DecodeInto(msg, pooledSubscribeBytes)
+		require.NoError(t, err)
+		require.Equal(t, len(pooledSubscribeBytes), n)
+		require.Equal(t, []string{"a/b", "c/#"}, msg.Topics())
+
+		id, err := msg.ID()
+		require.NoError(t, err)
+		require.Equal(t, IDType(7), id)
+
+		buf, err := Encode(msg)
+		require.NoError(t, err)
+		require.Equal(t, pooledSubscribeBytes, buf)
+
+		msg.Reset()
+	}
+
+	_, err = DecodeInto(msg, []byte{byte(PINGREQ << 4), 0})
+	require.EqualError(t, err, ErrInvalidMessageType.Error())
+
+	PutSubscribe(msg)
+
+	_, err = GetSubscribe(ProtocolVersion(9))
+	require.EqualError(t, err, ErrInvalidProtocolVersion.Error())
+}
+
+func BenchmarkSubscribeDecode(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		Decode(ProtocolV311, pooledSubscribeBytes) // nolint: errcheck
+	}
+}
+
+func BenchmarkSubscribeDecodePooled(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		msg, _ := GetSubscribe(ProtocolV311)
+		DecodeInto(msg, pooledSubscribeBytes) // nolint: errcheck
+		PutSubscribe(msg)
+	}
+}
+
+func TestSubscribeWriteTo(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(4)
+	require.NoError(t, msg.AddTopics([]string{"a/b", "c/#"}, []SubscriptionOptions{1, 2}))
+
+	var buf bytes.Buffer
+
+	var wt io.WriterTo = msg
+	n, err := wt.WriteTo(&buf)
+	require.NoError(t, err)
+
+	expected, err := Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(expected)), n)
+	require.Equal(t, expected, buf.Bytes())
+
+	// buffer is reused and message changes are reflected
+	require.True(t, msg.RemoveTopic("c/#"))
+	buf.Reset()
+
+	n, err = msg.WriteTo(&buf)
+	require.NoError(t, err)
+
+	expected, err = Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(expected)), n)
+	require.Equal(t, expected, buf.Bytes())
+}
+
+func TestSubscribeMarshalJSON(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(10)
+	require.NoError(t, msg.AddTopics([]string{"sport/tennis", "weather/ü/#"}, []SubscriptionOptions{1, 0}))
+
+	data, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"SUBSCRIBE","packetId":10,"subscriptions":[{"topic":"sport/tennis","qos":1},{"topic":"weather/ü/#","qos":0}]}`, string(data))
+
+	m, err = New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg = m.(*Subscribe)
+	msg.SetPacketID(11)
+	require.NoError(t, msg.AddTopic("a", NewSubscriptionOptions(QoS2, true, true, RetainHandlingDoNotRetain)))
+
+	data, err = json.Marshal(msg)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"SUBSCRIBE","packetId":11,"subscriptions":[{"topic":"a","qos":2,"noLocal":true,"retainAsPublished":true,"retainHandling":2}]}`, string(data))
+}
+
+func TestSubscribeDeadFilters(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	topics := []string{
+		"sensors/+/temp",
+		"metrics/#",
+		"$share/g/sensors/#",
+		"$share/g/alerts/#",
+		"+/status",
+		"#",
+		"/leading",
+		"$SYS/broker/uptime",
+	}
+
+	for _, topic := range topics {
+		require.NoError(t, msg.AddTopic(topic, 1))
+	}
+
+	prefixes := []string{"sensors/", "$SYS/broker", "devices"}
+
+	require.Equal(t, []string{"metrics/#", "$share/g/alerts/#", "/leading"}, msg.DeadFilters(prefixes))
+	require.Equal(t, topics, msg.DeadFilters(nil))
+
+	// wildcards never match system topics
+	require.Equal(t,
+		[]string{"sensors/+/temp", "metrics/#", "$share/g/sensors/#", "$share/g/alerts/#", "+/status", "#", "/leading"},
+		msg.DeadFilters([]string{"$SYS/broker"}))
+}
+
+func TestSubscribeAddTopicShareName(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		for _, topic := range []string{"$share/consumers/sport/#", "$share/g/+", "$share/g/a/b/c"} {
+			require.NoError(t, msg.AddTopic(topic, 1), topic)
+		}
+
+		for _, topic := range []string{"$share//sport", "$share/+/sport", "$share/#/sport", "$share/g+/sport", "$share/g#/sport"} {
+			require.EqualError(t, msg.AddTopic(topic, 1), ErrInvalidTopic.Error(), topic)
+		}
+
+		require.Equal(t, 3, msg.TopicCount())
+	}
+}
+
+func TestSubscribeSortTopics(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(7)
+	require.NoError(t, msg.AddTopics([]string{"c/d", "a/+", "b", "a/#", "$SYS/x"}, []SubscriptionOptions{2, 1, 0, 2, 1}))
+	require.True(t, msg.IncDelivered("b"))
+
+	unsorted, err := msg.MarshalBinary()
+	require.NoError(t, err)
+
+	msg.SortTopics()
+
+	require.Equal(t, []string{"$SYS/x", "a/#", "a/+", "b", "c/d"}, msg.Topics())
+	require.Equal(t, []SubscriptionOptions{1, 2, 1, 0, 2}, msg.Freeze().Options())
+
+	st, ok := msg.Stats("b")
+	require.True(t, ok)
+	require.Equal(t, uint64(1), st.Delivered)
+
+	st, ok = msg.Stats("c/d")
+	require.True(t, ok)
+	require.Equal(t, uint64(0), st.Delivered)
+
+	// same subscriptions added in other order encode into same bytes
+	m, err = New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	other := m.(*Subscribe)
+	other.SetPacketID(7)
+	require.NoError(t, other.AddTopics([]string{"a/#", "b", "$SYS/x", "c/d", "a/+"}, []SubscriptionOptions{2, 0, 1, 2, 1}))
+	other.SortTopics()
+
+	sorted, err := msg.MarshalBinary()
+	require.NoError(t, err)
+	require.NotEqual(t, unsorted, sorted)
+
+	otherSorted, err := other.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, sorted, otherSorted)
+}
+
+func TestSubscribeAppendTopic(t *testing.T) {
+	for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+		m, err := New(v, SUBSCRIBE)
+		require.NoError(t, err)
+
+		msg, ok := m.(*Subscribe)
+		require.True(t, ok, "Couldn't cast message type")
+
+		msg.SetPacketID(5)
+
+		buf, err := msg.MarshalBinary()
+		require.NoError(t, err)
+
+		// long enough for remaining length to grow from 1 to 2 and then 3 bytes
+		for i := 0; i < 300; i++ {
+			topic := strings.Repeat("t", i%120) + "/" + strconv.Itoa(i) + "/#"
+
+			buf, err = msg.AppendTopic(buf, topic, SubscriptionOptions(i%3))
+			require.NoError(t, err, topic)
+
+			expected, err := msg.MarshalBinary()
+			require.NoError(t, err)
+			require.Equal(t, expected, buf, "topic %d", i)
+		}
+
+		require.True(t, len(buf) > 16384)
+
+		decoded, _, err := Decode(v, buf)
+		require.NoError(t, err)
+		require.True(t, msg.Equal(decoded.(*Subscribe)))
+
+		before := append([]byte(nil), buf...)
+
+		_, err = msg.AppendTopic(buf, "a/#/b", 1)
+		require.Error(t, err)
+
+		_, err = msg.AppendTopic(buf[:len(buf)-1], "a", 1)
+		require.EqualError(t, err, ErrInvalidLength.Error())
+
+		_, err = msg.AppendTopic(nil, "a", 1)
+		require.EqualError(t, err, ErrInsufficientDataSize.Error())
+
+		require.Equal(t, before, buf)
+		require.Equal(t, 300, msg.TopicCount())
+	}
+}
+
+func TestSubscribeValidate(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	// packet ID not set
+	require.NoError(t, msg.AddTopic("a/b", 1))
+	require.EqualError(t, msg.Validate(), ErrPackedIDZero.Error())
+
+	msg.SetPacketID(0)
+	require.EqualError(t, msg.Validate(), ErrPackedIDZero.Error())
+
+	msg.SetPacketID(1)
+	require.NoError(t, msg.Validate())
+
+	var p Provider = msg
+	require.NoError(t, p.Validate())
+
+	// empty topic list
+	require.True(t, msg.RemoveTopic("a/b"))
+	require.EqualError(t, msg.Validate(), ErrEmptyPayload.Error())
+
+	// state not reachable with AddTopic
+	msg.topics = append(msg.topics, "a", "b/#/c")
+	msg.ops = append(msg.ops, 1, 0)
+
+	err = msg.Validate()
+	subErr, ok := err.(*SubscriptionError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, 1, subErr.Index)
+	require.Equal(t, "b/#/c", subErr.Topic)
+
+	msg.topics[1] = "b/c"
+	msg.ops[1] = 3
+	err = msg.Validate()
+	subErr, ok = err.(*SubscriptionError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, ErrInvalidQoS, subErr.Err)
+}
+
+func TestSubscribeCheckSubscriptionIDAllowed(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.CheckSubscriptionIDAllowed(true))
+	require.NoError(t, msg.CheckSubscriptionIDAllowed(false))
+
+	require.NoError(t, msg.SetSubscriptionIdentifier(12))
+	require.NoError(t, msg.CheckSubscriptionIDAllowed(true))
+	require.Equal(t, CodeSubscriptionIDNotSupported, msg.CheckSubscriptionIDAllowed(false))
+
+	m, err = New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg = m.(*Subscribe)
+	require.NoError(t, msg.CheckSubscriptionIDAllowed(false))
+}
+
+func TestSubscribeSizeDelta(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	msg.SetPacketID(1)
+
+	size := func() int {
+		sz, err := msg.Size()
+		require.NoError(t, err)
+		return sz
+	}
+
+	// remaining length 2 + 120 = 122
+	require.NoError(t, msg.AddTopic(strings.Repeat("a", 117), 1))
+	require.Equal(t, 124, size())
+
+	// stays within single byte of remaining length: 122 + 5 = 127
+	require.Equal(t, 5, msg.SizeDeltaIfAdd("bb"))
+
+	// crosses 127 boundary thus remaining length grows to 2 bytes
+	require.Equal(t, 7, msg.SizeDeltaIfAdd("ccc"))
+
+	before := size()
+	require.NoError(t, msg.AddTopic("ccc", 0))
+	require.Equal(t, before+7, size())
+
+	require.Equal(t, -7, msg.SizeDeltaIfRemove("ccc"))
+	require.Equal(t, 0, msg.SizeDeltaIfRemove("none"))
+
+	before = size()
+	require.True(t, msg.RemoveTopic("ccc"))
+	require.Equal(t, before-7, size())
+
+	for _, topic := range []string{"x", "yy/zz", strings.Repeat("q", 300)} {
+		delta := msg.SizeDeltaIfAdd(topic)
+		before = size()
+		require.NoError(t, msg.AddTopic(topic, 2))
+		require.Equal(t, before+delta, size(), topic)
+	}
+}
+
+func TestSubscribeTopicAt(t *testing.T) {
+	m, err := New(ProtocolV50, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	_, _, ok = msg.TopicAt(0)
+	require.False(t, ok)
+
+	require.NoError(t, msg.AddTopics([]string{"a/b", "c/#"}, []SubscriptionOptions{1, NewSubscriptionOptions(QoS2, true, false, RetainHandlingIfNotExists)}))
+
+	for i := 0; i < msg.TopicCount(); i++ {
+		topic, ops, ok := msg.TopicAt(i)
+		require.True(t, ok)
+		require.Equal(t, msg.Topics()[i], topic)
+		require.Equal(t, msg.Freeze().Options()[i], ops)
+	}
+
+	topic, ops, ok := msg.TopicAt(1)
+	require.True(t, ok)
+	require.Equal(t, "c/#", topic)
+	require.True(t, ops.NL())
+
+	for _, i := range []int{-1, 2, 100} {
+		topic, ops, ok = msg.TopicAt(i)
+		require.False(t, ok, i)
+		require.Equal(t, "", topic)
+		require.Equal(t, SubscriptionOptions(0), ops)
+	}
+}
+
+func TestSubscribeInvalidTopicFilter(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	msg, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.NoError(t, msg.AddTopics([]string{"a/+", "+/+/#", "+/+/+/+/+", "+/+/+/+"}, []SubscriptionOptions{0, 1, 1, 2}))
+
+	_, ok = msg.InvalidTopicFilter(TopicFilterOptions{})
+	require.False(t, ok)
+
+	_, ok = msg.InvalidTopicFilter(TopicFilterOptions{MaxWildcards: 5})
+	require.False(t, ok)
+
+	filter, ok := msg.InvalidTopicFilter(TopicFilterOptions{MaxWildcards: 4})
+	require.True(t, ok)
+	require.Equal(t, "+/+/+/+/+", filter)
+
+	filter, ok = msg.InvalidTopicFilter(TopicFilterOptions{MaxWildcards: 2})
+	require.True(t, ok)
+	require.Equal(t, "+/+/#", filter)
+}
+
+func TestSubscribeDecodeMisplacedWildcards(t *testing.T) {
+	// fixture used by decode tests before wildcard placement was validated
+	msgBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		37,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // topic name MSB (0)
+		8, // topic name LSB (7)
+		'v', 'o', 'l', 'a', 'n', 't', 'm', 'q',
+		0, // QoS
+		0, // topic name MSB (0)
+		8, // topic name LSB (8)
+		'/', 'a', '/', 'b', '/', '#', '/', 'c',
+		1,  // QoS
+		0,  // topic name MSB (0)
+		10, // topic name LSB (10)
+		'/', 'a', '/', 'b', '/', '#', '/', 'c', 'd', 'd',
+		2, // QoS
+	}
+
+	_, _, err := Decode(ProtocolV311, msgBytes)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+
+	for _, topic := range []string{"#/a", "a+", "a/b#", "$share/g/a/#/b", "$share/g+/a", "$share//a"} {
+		for _, v := range []ProtocolVersion{ProtocolV311, ProtocolV50} {
+			buf := []byte{byte(SUBSCRIBE<<4) | 2, 0, 0, 1}
+			if v == ProtocolV50 {
+				buf = append(buf, 0) // properties
+			}
+
+			buf = append(buf, 0, byte(len(topic)))
+			buf = append(buf, topic...)
+			buf = append(buf, 1)
+			buf[1] = byte(len(buf) - 2)
+
+			expected := CodeRefusedServerUnavailable
+			if v == ProtocolV50 {
+				expected = CodeMalformedPacket
+			}
+
+			_, _, err = Decode(v, buf)
+			require.EqualError(t, err, expected.Error(), topic)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package packet
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	topicSeparator      = '/'
+	topicSingleWildcard = "+"
+	topicMultiWildcard  = "#"
+	topicSharedPrefix   = "$share/"
+)
+
+// IsSystemTopic check if topic starts with $ and thus belongs to server specific topics (like $SYS)
+func IsSystemTopic(topic string) bool {
+	return len(topic) > 0 && topic[0] == '$'
+}
+
+// IsCatchAll check if filter is bare multi-level wildcard receiving all non-system topics
+// Shared subscriptions are checked by their topic filter
+func IsCatchAll(filter string) bool {
+	if _, f, ok := ParseSharedSubscription(filter); ok {
+		filter = f
+	}
+
+	return filter == topicMultiWildcard
+}
+
+// TopicMatches check if topic name matches given topic filter
+// [MQTT-4.7.2-1] filters starting with wildcard do not match topics starting with $
+func TopicMatches(filter, topic string) bool {
+	if IsSystemTopic(topic) && (strings.HasPrefix(filter, topicMultiWildcard) ||
+		strings.HasPrefix(filter, topicSingleWildcard)) {
+		return false
+	}
+
+	for {
+		fLevel, fRest, fMore := splitTopicLevel(filter)
+
+		// [MQTT-4.7.1-2] multi-level wildcard matches parent and any number of child levels
+		if fLevel == topicMultiWildcard {
+			return true
+		}
+
+		tLevel, tRest, tMore := splitTopicLevel(topic)
+
+		if fLevel != topicSingleWildcard && fLevel != tLevel {
+			return false
+		}
+
+		if !tMore {
+			return !fMore || fRest == topicMultiWildcard
+		}
+
+		if !fMore {
+			return false
+		}
+
+		filter, topic = fRest, tRest
+	}
+}
+
+// MatchSemanticsEqual check if filter matches topic identically under v3.1.1 and v5.0 rules
+// v3.1.1 has no shared subscriptions thus $share/{ShareName}/{filter} is matched there literally
+func MatchSemanticsEqual(filter, topic string) bool {
+	v5Filter := filter
+	if _, f, ok := ParseSharedSubscription(filter); ok {
+		v5Filter = f
+	}
+
+	return TopicMatches(filter, topic) == TopicMatches(v5Filter, topic)
+}
+
+// splitTopicLevel returns first level of the topic, rest of the topic and whether separator was found
+func splitTopicLevel(topic string) (string, string, bool) {
+	if i := strings.IndexByte(topic, topicSeparator); i >= 0 {
+		return topic[:i], topic[i+1:], true
+	}
+
+	return topic, "", false
+}
+
+// LevelCursor iterates over levels of topic without allocations
+type LevelCursor struct {
+	rest string
+	done bool
+}
+
+// NewLevelCursor allocate cursor over levels of topic
+func NewLevelCursor(topic string) LevelCursor {
+	return LevelCursor{rest: topic}
+}
+
+// Next returns next topic level. Second value is false when there are no levels left
+func (c *LevelCursor) Next() (string, bool) {
+	if c.done {
+		return "", false
+	}
+
+	level, rest, more := splitTopicLevel(c.rest)
+	c.rest = rest
+	c.done = !more
+
+	return level, true
+}
+
+// IsSharedSubscription check if topic is shared subscription of form $share/{ShareName}/{filter}
+func IsSharedSubscription(topic string) bool {
+	_, _, ok := ParseSharedSubscription(topic)
+	return ok
+}
+
+// ParseSharedSubscription splits shared subscription $share/{ShareName}/{filter} into share name and filter
+// ok is false if topic is not a shared subscription. Share name is not validated
+func ParseSharedSubscription(topic string) (shareName, filter string, ok bool) {
+	if !strings.HasPrefix(topic, topicSharedPrefix) {
+		return "", "", false
+	}
+
+	if shareName, filter, ok = splitTopicLevel(topic[len(topicSharedPrefix):]); !ok {
+		return "", "", false
+	}
+
+	return shareName, filter, true
+}
+
+// MakeShared wraps topic filter into shared subscription form $share/{ShareName}/{filter}
+func MakeShared(group, filter string) (string, error) {
+	if !validShareName(group) || !utf8.ValidString(group) {
+		return "", ErrInvalidArgs
+	}
+
+	if !validTopicFilter(filter) {
+		return "", ErrInvalidTopic
+	}
+
+	return topicSharedPrefix + group + string(topicSeparator) + filter, nil
+}
+
+// validShareName check share name of shared subscription
+func validShareName(name string) bool {
+	// [MQTT-4.8.2-1] [MQTT-4.8.2-2]
+	return len(name) != 0 && !strings.ContainsAny(name, "/+#")
+}
+
+// TopicFilterOptions policies applied by ValidTopicFilter on top of spec rules
+type TopicFilterOptions struct {
+	// ForbidRootWildcard rejects filters with first level being wildcard, e.g. +/a or #
+	ForbidRootWildcard bool
+	// ForbidEmptyLevels rejects filters with leading or trailing separator or empty level, e.g. /a, a/ or a//b
+	ForbidEmptyLevels bool
+	// MaxWildcards rejects filters with more wildcard levels than given, e.g. +/+/# has 3. 0 means no limit
+	MaxWildcards int
+	// NoSharedSubscriptions validates $share/ filters literally as v3.1.1 does, it has no shared subscriptions
+	NoSharedSubscriptions bool
+}
+
+// ValidTopicFilter check if topic filter is valid per spec and matches given policies
+// Shared subscriptions are validated by their share name and topic filter
+func ValidTopicFilter(filter string, opts TopicFilterOptions) bool {
+	if !opts.NoSharedSubscriptions && strings.HasPrefix(filter, topicSharedPrefix) {
+		name, f, ok := ParseSharedSubscription(filter)
+		if !ok || !validShareName(name) {
+			return false
+		}
+
+		filter = f
+	}
+
+	if !validTopicFilter(filter) {
+		return false
+	}
+
+	if opts.ForbidRootWildcard {
+		if level, _, _ := splitTopicLevel(filter); level == topicSingleWildcard || level == topicMultiWildcard {
+			return false
+		}
+	}
+
+	if opts.ForbidEmptyLevels || opts.MaxWildcards > 0 {
+		wildcards := 0
+
+		c := NewLevelCursor(filter)
+		for level, ok := c.Next(); ok; level, ok = c.Next() {
+			if opts.ForbidEmptyLevels && len(level) == 0 {
+				return false
+			}
+
+			if level == topicSingleWildcard || level == topicMultiWildcard {
+				wildcards++
+			}
+		}
+
+		if opts.MaxWildcards > 0 && wildcards > opts.MaxWildcards {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validTopicEncoding check topic is well-formed UTF-8 string without null character
+// [MQTT-1.5.3-1] [MQTT-1.5.3-2] [MQTT-4.7.3-2]
+func validTopicEncoding(topic string) bool {
+	return utf8.ValidString(topic) && strings.IndexByte(topic, 0) < 0
+}
+
+// validTopicFilter check filter is not empty and wildcards occupy entire level
+// with multi-level wildcard allowed as last level only
+func validTopicFilter(filter string) bool {
+	// [MQTT-4.7.3-1]
+	if len(filter) == 0 || !validTopicEncoding(filter) {
+		return false
+	}
+
+	return checkTopicWildcards(filter) == nil
+}
+
+// checkTopicWildcards check placement of wildcards within filter
+// returns error naming violated rule
+func checkTopicWildcards(filter string) error {
+	for {
+		level, rest, more := splitTopicLevel(filter)
+
+		if level != topicMultiWildcard && level != topicSingleWildcard {
+			// [MQTT-4.7.1-2]
+			if strings.IndexByte(level, '#') >= 0 {
+				return ErrMisplacedMultiLevelWildcard
+			}
+
+			// [MQTT-4.7.1-3]
+			if strings.IndexByte(level, '+') >= 0 {
+				return ErrMisplacedSingleLevelWildcard
+			}
+		}
+
+		if !more {
+			return nil
+		}
+
+		// [MQTT-4.7.1-2]
+		if level == topicMultiWildcard {
+			return ErrMisplacedMultiLevelWildcard
+		}
+
+		filter = rest
+	}
+}
+
+// CheckTopicLen validates topic is UTF8 string fitting into wire limit of length-prefixed string
+// and into configured limit of maxBytes. maxBytes <= 0 disables configured limit
+func CheckTopicLen(topic string, maxBytes int) error {
+	if !utf8.ValidString(topic) {
+		return ErrInvalidUtf8
+	}
+
+	if len(topic) > MaxLPString {
+		return ErrInvalidLPStringSize
+	}
+
+	if maxBytes > 0 && len(topic) > maxBytes {
+		return ErrTopicLengthExceeded
+	}
+
+	return nil
+}
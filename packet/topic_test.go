@@ -0,0 +1,241 @@
+package packet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicIsSystem(t *testing.T) {
+	require.True(t, IsSystemTopic("$SYS/broker/uptime"))
+	require.True(t, IsSystemTopic("$"))
+	require.False(t, IsSystemTopic("SYS/$broker"))
+	require.False(t, IsSystemTopic(""))
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"sport/tennis", "sport/tennis", true},
+		{"sport/tennis", "sport/tennis/player1", false},
+		{"sport/#", "sport", true},
+		{"sport/#", "sport/tennis/player1", true},
+		{"sport/tennis/#", "sport/tennis", true},
+		{"#", "sport/tennis", true},
+		{"+", "sport", true},
+		{"+", "sport/tennis", false},
+		{"+/tennis", "sport/tennis", true},
+		{"sport/+", "sport", false},
+		{"sport/+", "sport/", true},
+		{"+/+", "/finance", true},
+		{"/+", "/finance", true},
+		{"+", "/finance", false},
+		{"sport/+/player1", "sport/tennis/player1", true},
+		{"sport/+/player1", "sport/tennis/player2", false},
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+		{"$SYS/+/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/broker/uptime", "$SYS/broker/uptime", true},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.match, TopicMatches(tt.filter, tt.topic), "filter %q topic %q", tt.filter, tt.topic)
+	}
+}
+
+func TestTopicParseShared(t *testing.T) {
+	group, filter, ok := ParseSharedSubscription("$share/consumers/sport/tennis/#")
+	require.True(t, ok)
+	require.Equal(t, "consumers", group)
+	require.Equal(t, "sport/tennis/#", filter)
+	require.True(t, IsSharedSubscription("$share/consumers/sport/tennis/#"))
+
+	group, filter, ok = ParseSharedSubscription("$share//sport")
+	require.True(t, ok)
+	require.Equal(t, "", group)
+	require.Equal(t, "sport", filter)
+
+	for _, topic := range []string{"sport/tennis", "$share/consumers", "$shared/g/a", "$SYS/share/a"} {
+		_, _, ok = ParseSharedSubscription(topic)
+		require.False(t, ok, topic)
+		require.False(t, IsSharedSubscription(topic), topic)
+	}
+}
+
+func TestTopicMakeShared(t *testing.T) {
+	shared, err := MakeShared("consumers", "sport/tennis/#")
+	require.NoError(t, err)
+	require.Equal(t, "$share/consumers/sport/tennis/#", shared)
+
+	for _, group := range []string{"", "a/b", "a+", "#"} {
+		_, err = MakeShared(group, "sport/tennis")
+		require.EqualError(t, err, ErrInvalidArgs.Error(), group)
+	}
+
+	for _, filter := range []string{"", "sport/#/tennis", "sport/ten+nis", "sport#"} {
+		_, err = MakeShared("consumers", filter)
+		require.EqualError(t, err, ErrInvalidTopic.Error(), filter)
+	}
+}
+
+func TestTopicLevelCursor(t *testing.T) {
+	for topic, expected := range map[string][]string{
+		"sport/tennis/player1": {"sport", "tennis", "player1"},
+		"/finance":             {"", "finance"},
+		"sport/":               {"sport", ""},
+		"sport":                {"sport"},
+	} {
+		var levels []string
+
+		c := NewLevelCursor(topic)
+		for level, ok := c.Next(); ok; level, ok = c.Next() {
+			levels = append(levels, level)
+		}
+
+		require.Equal(t, expected, levels, topic)
+	}
+}
+
+func BenchmarkTopicLevelCursor(b *testing.B) {
+	topic := "a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u/v/w/x/y/z"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := NewLevelCursor(topic)
+		for _, ok := c.Next(); ok; _, ok = c.Next() {
+		}
+	}
+}
+
+func TestTopicCheckLen(t *testing.T) {
+	require.NoError(t, CheckTopicLen("a/b", 3))
+	require.EqualError(t, CheckTopicLen("a/bc", 3), ErrTopicLengthExceeded.Error())
+	require.NoError(t, CheckTopicLen("a/bc", 0))
+
+	topic := strings.Repeat("a", MaxLPString)
+	require.NoError(t, CheckTopicLen(topic, 0))
+	require.EqualError(t, CheckTopicLen(topic+"a", 0), ErrInvalidLPStringSize.Error())
+	require.EqualError(t, CheckTopicLen(topic+"a", 10), ErrInvalidLPStringSize.Error())
+
+	require.EqualError(t, CheckTopicLen(string([]byte{'a', 0xff}), 0), ErrInvalidUtf8.Error())
+}
+
+func TestTopicValidFilter(t *testing.T) {
+	for filter, valid := range map[string]bool{
+		"a/+":             true,
+		"+/a":             true,
+		"#":               true,
+		"a/#":             true,
+		"$share/g/+/a":    true,
+		"a/#/b":           false,
+		"a+":              false,
+		"":                false,
+		"$share/g/a/#/b":  false,
+		"sport/tennis/#x": false,
+		"$share//a":       false,
+		"$share/g+/a":     false,
+		"$share/g#/a":     false,
+		"$share/+/a":      false,
+		"$share/g":        false,
+		"$share/g/":       false,
+	} {
+		require.Equal(t, valid, ValidTopicFilter(filter, TopicFilterOptions{}), filter)
+	}
+
+	// without shared subscriptions $share/ is regular first level
+	opts := TopicFilterOptions{NoSharedSubscriptions: true}
+	for filter, valid := range map[string]bool{
+		"$share/g/+/a": true,
+		"$share//a":    true,
+		"$share/g":     true,
+		"$share/g+/a":  false,
+		"$share/+/a":   true,
+	} {
+		require.Equal(t, valid, ValidTopicFilter(filter, opts), filter)
+	}
+
+	opts = TopicFilterOptions{ForbidRootWildcard: true}
+	for filter, valid := range map[string]bool{
+		"a/+":          true,
+		"a/#":          true,
+		"+/a":          false,
+		"+":            false,
+		"#":            false,
+		"$share/g/+/a": false,
+	} {
+		require.Equal(t, valid, ValidTopicFilter(filter, opts), filter)
+	}
+}
+
+func TestTopicValidFilterEmptyLevels(t *testing.T) {
+	opts := TopicFilterOptions{ForbidEmptyLevels: true}
+
+	for filter, valid := range map[string]bool{
+		"a/b":           true,
+		"a/+/#":         true,
+		"$share/g/a/b":  true,
+		"/a":            false,
+		"a/":            false,
+		"a//b":          false,
+		"$share/g//a/b": false,
+	} {
+		require.Equal(t, valid, ValidTopicFilter(filter, opts), filter)
+		require.True(t, ValidTopicFilter(filter, TopicFilterOptions{}), filter)
+	}
+}
+
+func TestTopicValidFilterMaxWildcards(t *testing.T) {
+	opts := TopicFilterOptions{MaxWildcards: 3}
+
+	for filter, valid := range map[string]bool{
+		"a/b":              true,
+		"+/+/+":            true,
+		"+/a/+/#":          true,
+		"$share/g/+/+/#":   true,
+		"+/+/+/+":          false,
+		"+/+/+/#":          false,
+		"+/+/+/+/+":        false,
+		"$share/g/+/+/+/#": false,
+	} {
+		require.Equal(t, valid, ValidTopicFilter(filter, opts), filter)
+		require.True(t, ValidTopicFilter(filter, TopicFilterOptions{}), filter)
+	}
+}
+
+func TestTopicIsCatchAll(t *testing.T) {
+	require.True(t, IsCatchAll("#"))
+	require.True(t, IsCatchAll("$share/g1/#"))
+	require.False(t, IsCatchAll("a/#"))
+	require.False(t, IsCatchAll("+"))
+	require.False(t, IsCatchAll("/#"))
+}
+
+func TestMatchSemanticsEqual(t *testing.T) {
+	cases := []struct {
+		filter string
+		topic  string
+		equal  bool
+	}{
+		{"sport/+/score", "sport/tennis/score", true},
+		{"sport/#", "weather", true},
+		{"#", "$SYS/broker/uptime", true},
+		{"+/broker/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+		{"$share/g/sport/#", "sport/tennis", false},
+		{"$share/g/sport/#", "weather", true},
+		{"$share/g/#", "$share/g/sport", false},
+		{"$share/g/#", "$SYS/broker", true},
+		{"$share/g/+/b", "a/b", false},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.equal, MatchSemanticsEqual(c.filter, c.topic), c.filter+" "+c.topic)
+	}
+}
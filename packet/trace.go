@@ -0,0 +1,44 @@
+package packet
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Trace returns compact single line description of the message suitable for logs and test assertions
+// For example SUBSCRIBE id=10 [sport/tennis:1 weather/#:0]
+func Trace(m Provider) string {
+	var b bytes.Buffer
+
+	b.WriteString(m.Type().Name())
+
+	if id, err := m.ID(); err == nil {
+		b.WriteString(" id=")
+		b.WriteString(strconv.Itoa(int(id)))
+	}
+
+	switch msg := m.(type) {
+	case *Subscribe:
+		sub := msg.Freeze()
+		items := make([]string, 0, len(sub.topics))
+		for i, t := range sub.topics {
+			items = append(items, t+":"+strconv.Itoa(int(sub.ops[i].QoS())))
+		}
+		b.WriteString(" [" + strings.Join(items, " ") + "]")
+	case *UnSubscribe:
+		b.WriteString(" [" + strings.Join(msg.topics, " ") + "]")
+	case *SubAck:
+		items := make([]string, 0, len(msg.returnCodes))
+		for _, c := range msg.returnCodes {
+			items = append(items, strconv.Itoa(int(c)))
+		}
+		b.WriteString(" [" + strings.Join(items, " ") + "]")
+	case *Publish:
+		b.WriteString(" topic=" + msg.Topic() + " qos=" + strconv.Itoa(int(msg.QoS())))
+	case *ConnAck:
+		b.WriteString(" rc=" + strconv.Itoa(int(msg.ReturnCode())))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	m, err := New(ProtocolV311, SUBSCRIBE)
+	require.NoError(t, err)
+
+	sub, ok := m.(*Subscribe)
+	require.True(t, ok, "Couldn't cast message type")
+	sub.SetPacketID(10)
+	require.NoError(t, sub.AddTopic("sport/tennis", SubscriptionOptions(QoS1)))
+	require.NoError(t, sub.AddTopic("weather/#", SubscriptionOptions(QoS0)))
+	require.Equal(t, "SUBSCRIBE id=10 [sport/tennis:1 weather/#:0]", Trace(sub))
+
+	m, err = New(ProtocolV311, SUBACK)
+	require.NoError(t, err)
+
+	ack, ok := m.(*SubAck)
+	require.True(t, ok, "Couldn't cast message type")
+	ack.SetPacketID(10)
+	require.NoError(t, ack.AddReturnCodes([]ReasonCode{ReasonCode(QoS1), CodeUnspecifiedError}))
+	require.Equal(t, "SUBACK id=10 [1 128]", Trace(ack))
+
+	m, err = New(ProtocolV311, PUBLISH)
+	require.NoError(t, err)
+
+	pub, ok := m.(*Publish)
+	require.True(t, ok, "Couldn't cast message type")
+	require.NoError(t, pub.SetTopic("sport/tennis"))
+	require.Equal(t, "PUBLISH topic=sport/tennis qos=0", Trace(pub))
+
+	m, err = New(ProtocolV311, PINGREQ)
+	require.NoError(t, err)
+	require.Equal(t, "PINGREQ", Trace(m))
+}
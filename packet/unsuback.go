@@ -35,24 +35,29 @@ func (msg *UnSubAck) SetPacketID(v IDType) {
 	msg.setPacketID(v)
 }
 
-// ReturnCodes returns the list of QoS returns from the subscriptions sent in the SUBSCRIBE message.
+// ReturnCodes returns the list of reason codes for each topic filter sent in the UNSUBSCRIBE message.
+// V5.0 ONLY
 func (msg *UnSubAck) ReturnCodes() []ReasonCode {
 	return msg.returnCodes
 }
 
-// AddReturnCodes sets the list of QoS returns from the subscriptions sent in the SUBSCRIBE message.
-// An error is returned if any of the QoS values are not valid.
+// AddReturnCodes appends reason codes for topic filters sent in the UNSUBSCRIBE message.
+// An error is returned if any of the codes is not valid for UNSUBACK, in that case none of them is added.
+// V5.0 ONLY, V3.1.1 UNSUBACK has no payload
 func (msg *UnSubAck) AddReturnCodes(ret []ReasonCode) error {
+	if msg.version != ProtocolV50 {
+		return ErrNotSupported
+	}
+
 	for _, c := range ret {
-		if msg.version == ProtocolV50 && !c.IsValidForType(msg.mType) {
-			return ErrInvalidReturnCode
-		} else if !QosType(c).IsValidFull() {
+		// v5.0 [MQTT-3.11.3]
+		if !c.IsValidForType(msg.mType) {
 			return ErrInvalidReturnCode
 		}
-
-		msg.returnCodes = append(msg.returnCodes, c)
 	}
 
+	msg.returnCodes = append(msg.returnCodes, ret...)
+
 	return nil
 }
 
@@ -61,8 +66,38 @@ func (msg *UnSubAck) AddReturnCode(ret ReasonCode) error {
 	return msg.AddReturnCodes([]ReasonCode{ret})
 }
 
+// Validate checks message has packet ID set and reason codes are valid for UNSUBACK
+func (msg *UnSubAck) Validate() error {
+	// [MQTT-2.3.1-1]
+	if id, err := msg.ID(); err != nil || id == 0 {
+		return ErrPackedIDZero
+	}
+
+	if msg.version != ProtocolV50 {
+		if len(msg.returnCodes) != 0 {
+			return ErrNotSupported
+		}
+
+		return nil
+	}
+
+	for _, c := range msg.returnCodes {
+		// v5.0 [MQTT-3.11.3]
+		if !c.IsValidForType(msg.mType) {
+			return ErrInvalidReturnCode
+		}
+	}
+
+	return nil
+}
+
 // decode message
 func (msg *UnSubAck) decodeMessage(from []byte) (int, error) {
+	// V3.1.1 [MQTT-3.11.2] UNSUBACK carries packet ID only and has no payload
+	if msg.version < ProtocolV50 && msg.remLen != 2 {
+		return 0, CodeRefusedServerUnavailable
+	}
+
 	offset := msg.decodePacketID(from)
 
 	if msg.version == ProtocolV50 && (int(msg.remLen)-offset) > 0 {
@@ -71,6 +106,23 @@ func (msg *UnSubAck) decodeMessage(from []byte) (int, error) {
 		if err != nil {
 			return offset, err
 		}
+
+		numCodes := int(msg.remLen) - offset
+		if numCodes < 0 || offset+numCodes > len(from) {
+			return offset, CodeMalformedPacket
+		}
+
+		// v5.0 [MQTT-3.11.3] one reason code per topic filter in UNSUBSCRIBE
+		for i, c := range from[offset : offset+numCodes] {
+			code := ReasonCode(c)
+			if !code.IsValidForType(msg.mType) {
+				return offset + i, CodeProtocolError
+			}
+
+			msg.returnCodes = append(msg.returnCodes, code)
+		}
+
+		offset += numCodes
 	}
 
 	return offset, nil
@@ -89,6 +141,11 @@ func (msg *UnSubAck) encodeMessage(to []byte) (int, error) {
 		var n int
 		n, err = msg.properties.encode(to[offset:])
 		offset += n
+
+		for _, c := range msg.returnCodes {
+			to[offset] = byte(c)
+			offset++
+		}
 	}
 
 	return offset, err
@@ -99,7 +156,7 @@ func (msg *UnSubAck) size() int {
 	total := 2
 
 	if msg.version == ProtocolV50 {
-		total += int(msg.properties.FullLen())
+		total += int(msg.properties.FullLen()) + len(msg.returnCodes)
 	}
 
 	return total
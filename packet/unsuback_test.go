@@ -119,3 +119,106 @@ func TestUnSubAckDecodeEncodeEquiv(t *testing.T) {
 	require.NoError(t, err, "Error decoding message.")
 	require.Equal(t, len(msgBytes), n3, "Error decoding message.")
 }
+
+// test v3.1.1 UNSUBACK with payload
+func TestUnSubAckMessageDecodeTrailingBytes(t *testing.T) {
+	msgBytes := []byte{
+		byte(UNSUBACK << 4),
+		3,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // unexpected payload
+	}
+
+	_, _, err := Decode(ProtocolV311, msgBytes)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+}
+
+func TestUnSubAckV5ReasonCodes(t *testing.T) {
+	msgBytes := []byte{
+		byte(UNSUBACK << 4),
+		6,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // properties length
+		byte(CodeSuccess),
+		byte(CodeNoSubscriptionExisted),
+		byte(CodeNotAuthorized),
+	}
+
+	m, n, err := Decode(ProtocolV50, msgBytes)
+	require.NoError(t, err)
+	require.Equal(t, len(msgBytes), n)
+
+	msg, ok := m.(*UnSubAck)
+	require.True(t, ok, "Invalid message type")
+	require.Equal(t, []ReasonCode{CodeSuccess, CodeNoSubscriptionExisted, CodeNotAuthorized}, msg.ReturnCodes())
+
+	dst, err := Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, msgBytes, dst)
+
+	m, err = New(ProtocolV50, UNSUBACK)
+	require.NoError(t, err)
+
+	msg = m.(*UnSubAck)
+	msg.SetPacketID(7)
+	require.NoError(t, msg.AddReturnCodes([]ReasonCode{
+		CodeSuccess,
+		CodeNoSubscriptionExisted,
+		CodeUnspecifiedError,
+		CodeImplementationSpecificError,
+		CodeNotAuthorized,
+		CodeInvalidTopicFilter,
+		CodePacketIDInUse,
+	}))
+	require.EqualError(t, msg.AddReturnCodes([]ReasonCode{CodeSuccess, CodeMalformedPacket}), ErrInvalidReturnCode.Error())
+	require.Len(t, msg.ReturnCodes(), 7)
+
+	dst, err = Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, byte(2+1+7), dst[1])
+
+	invalid := []byte{
+		byte(UNSUBACK << 4),
+		4,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // properties length
+		byte(CodeMalformedPacket),
+	}
+
+	_, _, err = Decode(ProtocolV50, invalid)
+	require.EqualError(t, err, CodeProtocolError.Error())
+
+	m, err = New(ProtocolV311, UNSUBACK)
+	require.NoError(t, err)
+	require.EqualError(t, m.(*UnSubAck).AddReturnCode(CodeSuccess), ErrNotSupported.Error())
+}
+
+func TestUnSubAckValidate(t *testing.T) {
+	m, err := New(ProtocolV50, UNSUBACK)
+	require.NoError(t, err)
+
+	msg, ok := m.(*UnSubAck)
+	require.True(t, ok, "Couldn't cast message type")
+
+	require.EqualError(t, msg.Validate(), ErrPackedIDZero.Error())
+
+	msg.SetPacketID(3)
+	require.NoError(t, msg.AddReturnCodes([]ReasonCode{CodeSuccess, CodeNoSubscriptionExisted}))
+	require.NoError(t, msg.Validate())
+
+	msg.returnCodes = append(msg.returnCodes, CodeRefusedIdentifierRejected)
+	require.EqualError(t, msg.Validate(), ErrInvalidReturnCode.Error())
+
+	m, err = New(ProtocolV311, UNSUBACK)
+	require.NoError(t, err)
+
+	msg = m.(*UnSubAck)
+	msg.SetPacketID(3)
+	require.NoError(t, msg.Validate())
+
+	msg.returnCodes = []ReasonCode{CodeSuccess}
+	require.EqualError(t, msg.Validate(), ErrNotSupported.Error())
+}
@@ -57,15 +57,31 @@ func (msg *UnSubscribe) SetPacketID(v IDType) {
 // when io.Reader returns EOF or error. The first return value is the number of
 // bytes read from io.Reader. The second is error if decode encounters any problems.
 func (msg *UnSubscribe) decodeMessage(src []byte) (int, error) {
+	rejectMalformed := CodeRefusedServerUnavailable
+	if msg.version == ProtocolV50 {
+		rejectMalformed = CodeMalformedPacket
+	}
+
+	// packet must not be read beyond remaining length
+	packetLen := int(msg.remLen)
+	if packetLen > len(src) {
+		packetLen = len(src)
+	}
+
+	if packetLen < 2 {
+		return 0, rejectMalformed
+	}
+
 	total := msg.decodePacketID(src)
 
-	remLen := int(msg.remLen) - total
-	for remLen > 0 {
-		t, n, err := ReadLPBytes(src[total:])
-		total += n
+	// [MQTT-3.10.3] payload is list of topic filters without options byte
+	// thus remaining length must be consumed by topic filters exactly
+	for total < packetLen {
+		t, n, err := ReadLPBytes(src[total:packetLen])
 		if err != nil {
-			return total, err
+			return total, rejectMalformed
 		}
+		total += n
 
 		// [MQTT-3.10.3-1]
 		if !utf8.Valid(t) {
@@ -73,8 +89,6 @@ func (msg *UnSubscribe) decodeMessage(src []byte) (int, error) {
 		}
 
 		msg.topics = append(msg.topics, string(t))
-
-		remLen = remLen - n - 1
 	}
 
 	// [MQTT-3.10.3-2]
@@ -83,7 +97,7 @@ func (msg *UnSubscribe) decodeMessage(src []byte) (int, error) {
 		if msg.version <= ProtocolV50 {
 			rejectReason = CodeRefusedServerUnavailable
 		}
-		return 0, rejectReason
+		return total, rejectReason
 	}
 
 	return total, nil
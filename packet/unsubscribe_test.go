@@ -79,6 +79,15 @@ func TestUnSubscribeMessageDecode2(t *testing.T) {
 	_, _, err := Decode(ProtocolV311, msgBytes)
 
 	require.Error(t, err)
+
+	// no topics, offset points past packet ID
+	_, _, err = DecodeWithOffset(ProtocolV311, msgBytes)
+	require.Error(t, err)
+
+	decodeErr, ok := err.(*DecodeError)
+	require.True(t, ok, "Invalid error type")
+	require.Equal(t, UNSUBSCRIBE, decodeErr.Type)
+	require.Equal(t, len(msgBytes), decodeErr.Offset)
 }
 
 func TestUnSubscribeMessageEncode(t *testing.T) {
@@ -167,3 +176,48 @@ func TestUnSubscribeDecodeEncodeEquiv(t *testing.T) {
 	require.NoError(t, err, "Error decoding message")
 	require.Equal(t, len(msgBytes), n3, "Raw message length does not match")
 }
+
+// test payload carries topic filters only and no options byte is consumed
+func TestUnSubscribeDecodeNoOptionsByte(t *testing.T) {
+	msgBytes := []byte{
+		byte(UNSUBSCRIBE<<4) | 2,
+		14,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // topic name MSB (0)
+		1, // topic name LSB (1)
+		'a',
+		0, // topic name MSB (0)
+		1, // topic name LSB (1)
+		'b',
+		0, // topic name MSB (0)
+		1, // topic name LSB (1)
+		'c',
+		0, // topic name MSB (0)
+		1, // topic name LSB (1)
+		'#',
+	}
+
+	m, n, err := Decode(ProtocolV311, msgBytes)
+	require.NoError(t, err)
+	require.Equal(t, len(msgBytes), n)
+
+	msg, ok := m.(*UnSubscribe)
+	require.True(t, ok, "Invalid message type")
+	require.Equal(t, []string{"a", "b", "c", "#"}, msg.Topics())
+
+	// trailing byte looking like QoS of SUBSCRIBE
+	withQoS := []byte{
+		byte(UNSUBSCRIBE<<4) | 2,
+		8,
+		0, // packet ID MSB (0)
+		7, // packet ID LSB (7)
+		0, // topic name MSB (0)
+		3, // topic name LSB (3)
+		'a', '/', 'b',
+		1, // QoS
+	}
+
+	_, _, err = Decode(ProtocolV311, withQoS)
+	require.EqualError(t, err, CodeRefusedServerUnavailable.Error())
+}
@@ -0,0 +1,46 @@
+package packet
+
+// VendorPayload variable header and payload of vendor specific packet type.
+// It is implemented outside of the package and carried by Vendor message
+type VendorPayload interface {
+	// Decode reads content from buffer holding exactly remaining length bytes of the packet
+	Decode([]byte) (int, error)
+
+	// Encode writes content into buffer of at least Size bytes
+	Encode([]byte) (int, error)
+
+	// Size returns length of encoded content
+	Size() int
+}
+
+// Vendor message of packet type registered with RegisterType
+type Vendor struct {
+	header
+
+	payload VendorPayload
+}
+
+var _ Provider = (*Vendor)(nil)
+
+func newVendor(p VendorPayload) *Vendor {
+	return &Vendor{payload: p}
+}
+
+// Payload returns content of the message allocated by factory the packet type is registered with
+func (msg *Vendor) Payload() VendorPayload {
+	return msg.payload
+}
+
+// decode message
+func (msg *Vendor) decodeMessage(from []byte) (int, error) {
+	return msg.payload.Decode(from[:msg.remLen])
+}
+
+func (msg *Vendor) encodeMessage(to []byte) (int, error) {
+	return msg.payload.Encode(to)
+}
+
+// Len of message
+func (msg *Vendor) size() int {
+	return msg.payload.Size()
+}
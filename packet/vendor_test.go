@@ -0,0 +1,89 @@
+package packet_test
+
+import (
+	"testing"
+
+	"github.com/VolantMQ/volantmq/packet"
+	"github.com/stretchr/testify/require"
+)
+
+// rawPayload vendor payload implemented outside of packet package
+type rawPayload struct {
+	data []byte
+}
+
+func (p *rawPayload) Decode(from []byte) (int, error) {
+	if len(from) == 0 {
+		return 0, packet.ErrInsufficientDataSize
+	}
+
+	p.data = append([]byte(nil), from...)
+	return len(from), nil
+}
+
+func (p *rawPayload) Encode(to []byte) (int, error) {
+	return copy(to, p.data), nil
+}
+
+func (p *rawPayload) Size() int {
+	return len(p.data)
+}
+
+func TestVendorRegisterType(t *testing.T) {
+	factory := func() packet.VendorPayload {
+		return &rawPayload{}
+	}
+
+	require.EqualError(t, packet.RegisterType(packet.ProtocolV311, packet.PUBLISH, factory), packet.ErrInvalidMessageType.Error())
+	require.EqualError(t, packet.RegisterType(packet.ProtocolV50, packet.AUTH, factory), packet.ErrInvalidMessageType.Error())
+	require.EqualError(t, packet.RegisterType(packet.ProtocolV311, packet.Type(16), factory), packet.ErrInvalidMessageType.Error())
+	require.EqualError(t, packet.RegisterType(packet.ProtocolV311, packet.AUTH, nil), packet.ErrInvalidArgs.Error())
+
+	require.NoError(t, packet.RegisterType(packet.ProtocolV311, packet.AUTH, factory))
+	defer packet.UnregisterType(packet.ProtocolV311, packet.AUTH)
+
+	msgBytes := []byte{
+		byte(packet.AUTH << 4),
+		3,
+		'a', 'b', 'c',
+		// next packet must not be consumed by vendor payload
+		byte(packet.PINGREQ << 4),
+		0,
+	}
+
+	m, n, err := packet.Decode(packet.ProtocolV311, msgBytes)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	msg, ok := m.(*packet.Vendor)
+	require.True(t, ok, "Invalid message type")
+	require.Equal(t, packet.AUTH, msg.Type())
+
+	p, ok := msg.Payload().(*rawPayload)
+	require.True(t, ok, "Invalid payload type")
+	require.Equal(t, []byte("abc"), p.data)
+
+	buf, err := packet.Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, msgBytes[:5], buf)
+
+	// payload errors are reported by decode
+	_, _, err = packet.Decode(packet.ProtocolV311, []byte{byte(packet.AUTH << 4), 0})
+	require.EqualError(t, err, packet.ErrInsufficientDataSize.Error())
+
+	// built message
+	m, err = packet.New(packet.ProtocolV311, packet.AUTH)
+	require.NoError(t, err)
+
+	msg, ok = m.(*packet.Vendor)
+	require.True(t, ok, "Invalid message type")
+	msg.Payload().(*rawPayload).data = []byte("xy")
+
+	buf, err = packet.Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(packet.AUTH << 4), 2, 'x', 'y'}, buf)
+
+	// registration is per protocol version
+	_, _, err = packet.Decode(packet.ProtocolV31, msgBytes)
+	require.EqualError(t, err, packet.ErrInvalidMessageType.Error())
+}
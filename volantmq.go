@@ -70,6 +70,17 @@ type ServerConfig struct {
 	// MaxPacketSize
 	MaxPacketSize uint32
 
+	// MaxRxPacketRate maximum amount of packets per second server accepts from each connection
+	// Connection exceeding the rate is closed with CodeMessageRateTooHigh
+	// If not set than default is 0 which means unlimited
+	MaxRxPacketRate int
+
+	// RxInspect called with raw bytes of every packet received before it is decoded
+	// Returning error rejects the packet and closes connection. If error is not packet.ReasonCode
+	// connection is closed with CodeAdministrativeAction
+	// If not set than packets are not inspected
+	RxInspect func(raw []byte) error
+
 	// AllowOverlappingSubscriptions tells server how to handle overlapping subscriptions from within one client
 	// if true server will send only one publish with max subscribed QoS even there are n subscriptions
 	// if false server will send as many publishes as amount of subscriptions matching publish topic exists
@@ -259,6 +270,8 @@ func NewServer(config *ServerConfig) (Server, error) {
 		TopicAliasMaximum:             0xFFFF,
 		ReceiveMax:                    types.DefaultReceiveMax,
 		MaxPacketSize:                 types.DefaultMaxPacketSize,
+		MaxRxPacketRate:               s.MaxRxPacketRate,
+		RxInspect:                     s.RxInspect,
 		MaximumQoS:                    packet.QoS2,
 	}
 